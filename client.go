@@ -3,10 +3,16 @@ package ctdx
 import (
 	"os"
 	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"sync/atomic"
 	"strings"
 	"strconv"
 	"io/ioutil"
-    "test_tdx/ctdx/comm"
+	"path/filepath"
+    "github.com/datochan/ctdx/comm"
 
 	"github.com/kniren/gota/series"
 	"github.com/kniren/gota/dataframe"
@@ -16,31 +22,115 @@ import (
 	"github.com/datochan/gcom/utils"
 	"github.com/datochan/gcom/logger"
 
-    pkg "test_tdx/ctdx/packet"
+    pkg "github.com/datochan/ctdx/packet"
 )
 
 const (
-	stockBonusFinishedIdx = 0x1100   // 权息数据获取结束的标识符
+	quotesBatchSize = 80             // 单次请求实时行情的最大证券数量
+	ticksBatchSize = 2000            // 单次请求分笔成交的最大数量
+	fileChunkSize = 0x7530           // 下载文件时每块的大小
+	stockBaseBatchSize = 0x03E8      // 单次请求股票列表的最大数量
+	defaultRequestTimeout = 30 * time.Second  // 默认的单个请求等待应答的时间
+	defaultMaxInFlight = 0x0100      // 默认的同时等待应答的请求数上限
 )
 
+var (
+	ErrNotConnected    = errors.New("尚未连接服务器")
+	ErrConnectTimeout  = errors.New("连接服务器超时")
+	ErrRequestTimeout  = errors.New("等待服务器应答超时")
+	ErrRequestCanceled = errors.New("请求已取消")
+	ErrDisconnected    = errors.New("与服务器的连接已断开")
+	ErrClosed          = errors.New("连接已关闭")
+)
+
+// 与服务器的连接状态
+type ConnState int32
+
+const (
+	StateClosed     ConnState = iota // 未连接或连接已断开
+	StateConnecting                  // 正在建立连接并初始化市场信息
+	StateReady                       // 可以发送请求
+)
+
+func (state ConnState) String() string {
+	switch state {
+	case StateConnecting: return "Connecting"
+	case StateReady: return "Ready"
+	}
+	return "Closed"
+}
+
+/**
+ * 一次性的结束通知(连接断开、重连结束), 结束时关闭ch, reason 为结束的原因
+ */
+type connDone struct {
+	ch     chan struct{}
+	once   sync.Once
+	reason error
+}
+
+func newConnDone() *connDone {
+	return &connDone{ch: make(chan struct{})}
+}
+
+func (done *connDone) close(reason error) {
+	done.once.Do(func() {
+		done.reason = reason
+		close(done.ch)
+	})
+}
+
 type TdxClient struct {
+	connLock    sync.RWMutex  // 保护重连时会被替换的 session, done, reconnecting, capture
 	session     *cnet.SyncSession
+	protocol    *pkg.TdxPacketProtocolImpl  // 当前连接的协议, 发送请求前由其调用拦截器
 	dispatcher  *CTdxDispatcher
-
-    bonusFinishedChan chan string   // 用于更新权息数据时同步已处理的数据
-
-	Finished    chan interface{}
+	state       int32         // ConnState, 原子读写
+	done        *connDone     // 当前连接的断开通知
+	closed      int32         // 主动调用 Close 后为1, 不再自动重连
+	hostsRanked bool          // 候选服务器是否已按探测结果排序
+	hostIdx     int           // 当前(或最后一次连接成功的)服务器在 Hosts 中的位置
+	lastActive  int64         // 最后一次发送请求的时间(UnixNano), 原子读写
+	limiter     *requestLimiter  // 发送请求前的限速及发送窗口
+	reconnecting *connDone    // 正在进行的自动重连, 结束时 reason 为nil表示重连成功
+
+	Disconnected chan error     // 连接意外断开(开启自动重连时为重连失败)时发送原因(有缓冲), 主动调用 Close 时不发送
+	Reconnect   *ReconnectPolicy // 不为空时连接意外断开后自动重连
+	Hosts       []string      // 候选服务器, 为空时使用配置中的 data_hosts 或 data_host
+	HeartbeatInterval time.Duration  // 连接空闲超过此时间后发送心跳请求, 0为不发送
+	RateLimit   float64       // 每秒最多发送的请求数, 0为不限制, 每次连接(包括自动重连)时读取
+	MaxInFlight int           // 同时等待应答的请求数上限, 0为不限制, 每次连接(包括自动重连)时读取
+	MaxBodySize int           // 应答封包体允许的最大长度, 0为不限制
+	Interceptors []pkg.Interceptor  // 收发封包的拦截器, 每次连接(包括重连)时生效
+
+	Finished    chan interface{}    // UpdateXXX 成功后发送的通知(有缓冲), 兼容旧的用法
+	RequestTimeout time.Duration   // ctx 没有设置截止时间时单个请求等待应答的时间, 0为不限制
 	Configure   comm.IConfigure
 	MainVersion float32		// 软件版本 = 7.29
 	CoreVersion float32		// 数据引擎版本 = 5.895
 	lastTrade   LastTradeModel
+	CapturePath string     // 不为空时将收发的所有封包记录到此文件
+	UnknownPacketPath string  // 不为空时将未知的应答封包保存到此目录, 按 EventId/CmdId 分子目录
+	capture     *pkg.CaptureWriter  // 重连时沿用的封包记录, 由 connLock 保护
+	metrics     *Metrics
+	replayed    *ReplayResult  // OnStockBase 等处理过程解析出的数据
 
 	stockBaseDF    dataframe.DataFrame
 	stockbonusDF   dataframe.DataFrame
 }
 
 func NewDefaultTdxClient(configure comm.IConfigure) *TdxClient {
-	return &TdxClient{MainVersion:7.29, CoreVersion:5.895, Configure:configure, Finished:make(chan interface{})}
+	client := &TdxClient{MainVersion:7.29, CoreVersion:5.895, Configure:configure, Finished:make(chan interface{}, 1),
+		Disconnected:make(chan error, 1), RequestTimeout:defaultRequestTimeout, MaxInFlight:defaultMaxInFlight}
+	client.metrics = newMetrics(client)
+	return client
+}
+
+/**
+ * 当前的连接状态
+ */
+func (client *TdxClient) State() ConnState {
+	return ConnState(atomic.LoadInt32(&client.state))
 }
 
 func (client *TdxClient) GetLastTradeDate() uint32 {
@@ -51,279 +141,1029 @@ func (client *TdxClient) GetLastTradeDate() uint32 {
  * 关闭连接
  */
 func (client *TdxClient) Close() {
-	if nil != client.session {
-		client.session.Close()
+	atomic.StoreInt32(&client.closed, 1)
+	atomic.StoreInt32(&client.state, int32(StateClosed))
+
+	client.connLock.RLock()
+	session, done, reconnecting := client.session, client.done, client.reconnecting
+	client.connLock.RUnlock()
+
+	if nil != session {
+		session.Close()
+		done.close(ErrClosed)
 	}
+	if nil != reconnecting { reconnecting.close(ErrClosed) }
+
+	client.connLock.Lock()
+	capture := client.capture
+	client.capture = nil
+	client.connLock.Unlock()
+
+	// 接收协程可能仍在记录应答, 由 CaptureWriter 的锁保证关闭与写入互斥
+	if nil != capture { capture.Close() }
 }
 
 /**
- * 与服务器建立TCP连接
+ * 当前的连接、协议及其断开通知, 连接不可用时返回的 session 为nil
  */
-func (client *TdxClient) Conn(){
-	var err error
-	swProtocol := pkg.NewDefaultProtocol()
-	client.dispatcher = NewCTdxDispatcher()
+func (client *TdxClient) currentSession() (*cnet.SyncSession, *pkg.TdxPacketProtocolImpl, *connDone) {
+	client.connLock.RLock()
+	defer client.connLock.RUnlock()
 
-	client.session, err = cnet.NewSyncSession("tcp", client.Configure.GetTdx().Server.DataHost,
-		swProtocol, client.dispatcher.HandleProc, 0)
-	if err != nil {
-		logger.Error(fmt.Sprintf("创建服务器链接失败,err: %v", err))
-		os.Exit(0)
+	if StateClosed == client.State() { return nil, nil, client.done }
+	return client.session, client.protocol, client.done
+}
+
+/**
+ * 发送请求, 返回等待其应答的 Future
+ * 发送前按 RateLimit 限速, 等待应答的请求数达到 MaxInFlight 时等待其它请求完成
+ * callback 不为空时在收到应答后于接收协程中先调用
+ */
+func (client *TdxClient) call(ctx context.Context, reqNode pkg.RequestNode, callback func(pkg.ResponseNode)) (*Future, error) {
+	session, protocol, done := client.currentSession()
+	if nil == session { return nil, ErrNotConnected }
+
+	if err := client.limiter.acquire(ctx); nil != err { return nil, err }
+
+	future, err := client.dispatcher.Register(ctx, &reqNode, callback)
+	if nil != err { client.limiter.release(); return nil, err }
+	future.conn = done
+
+	// 拦截器放弃发送时连接仍然可用
+	if err := protocol.InterceptSend(&reqNode); nil != err {
+		client.dispatcher.Cancel(future)
+		return nil, err
+	}
+
+	if err := session.Send(reqNode); nil != err {
+		// 发送失败说明连接已断开, 关闭连接以便自动重连后重新发送
+		logger.Error("发送请求失败, 断开连接: %v", err)
+		client.dispatcher.Cancel(future)
+		session.Close()
+		return nil, ErrDisconnected
+	}
+
+	atomic.StoreInt64(&client.lastActive, time.Now().UnixNano())
+	return future, nil
+}
+
+/**
+ * 等待 Future 的应答, ctx 没有截止时间时最多等待 RequestTimeout, 超时或取消时从分发器中移除该请求
+ */
+func (client *TdxClient) wait(ctx context.Context, future *Future) (pkg.ResponseNode, error) {
+	if _, ok := ctx.Deadline(); !ok && 0 < client.RequestTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.RequestTimeout)
+		defer cancel()
+	}
+
+	select {
+	case respNode := <-future.Done():
+		return respNode, nil
+	case <-ctx.Done():
+		client.dispatcher.Cancel(future)
+		return pkg.ResponseNode{}, contextError(ctx.Err())
+	case <-future.conn.ch:
+		client.dispatcher.Cancel(future)
+		return pkg.ResponseNode{}, future.conn.reason
+	}
+}
+
+/**
+ * 连接断开时的处理过程, 只有意外断开时才通知 Disconnected
+ */
+func (client *TdxClient) onSessionClosed(done *connDone) {
+	var reconnecting *connDone
+
+	// 与 call 互斥, 请求发现连接已断开时重连一定已经登记
+	client.connLock.Lock()
+	prevState := ConnState(atomic.SwapInt32(&client.state, int32(StateClosed)))
+	if StateReady == prevState && nil != client.Reconnect && 0 == atomic.LoadInt32(&client.closed) {
+		reconnecting = newConnDone()
+		client.reconnecting = reconnecting
+	}
+	client.connLock.Unlock()
+
+	if StateClosed == prevState {
+		done.close(ErrClosed)
 		return
 	}
 
-	client.session.SetCloseCallback(func(*cnet.Session) {
-		logger.Info("服务器链接已关闭!")
-		os.Exit(0)
-	})
+	logger.Info("服务器链接已关闭!")
+	client.metrics.disconnected()
 
-	client.session.Start()
+	if nil != reconnecting {
+		done.close(ErrDisconnected)
+		go client.reconnect(reconnecting)
+		return
+	}
 
-	// 注册设备信息
-	client.session.Send(pkg.GenerateDeviceNode(client.MainVersion, client.CoreVersion))
+	done.close(ErrDisconnected)
+	if StateReady == prevState { client.notifyDisconnected(ErrDisconnected) }
+}
 
-	// 设置市场最后交易信息
-	lastHQInfo := pkg.GenerateMarketInitInfo()
-	client.dispatcher.AddHandler(uint32(lastHQInfo.EventId), client.OnMarketInitInfo)
-	client.session.Send(lastHQInfo)
+/**
+ * 通知 Disconnected 的消费方连接已断开, 没有消费方时不阻塞
+ */
+func (client *TdxClient) notifyDisconnected(reason error) {
+	select {
+	case client.Disconnected <- reason:
+	default:
+	}
+}
+
+/**
+ * 将 ctx 结束的原因转换为请求的错误
+ */
+func contextError(err error) error {
+	if context.DeadlineExceeded == err { return ErrRequestTimeout }
+	return ErrRequestCanceled
+}
+
+/**
+ * 发送请求并等待应答, 不处理连接断开
+ */
+func (client *TdxClient) roundTrip(ctx context.Context, reqNode pkg.RequestNode) (pkg.ResponseNode, error) {
+	future, err := client.call(ctx, reqNode, nil)
+	if nil != err { return pkg.ResponseNode{}, err }
+
+	return client.wait(ctx, future)
+}
+
+/**
+ * 发送请求并等待应答, 连接断开且正在自动重连时, 重连成功后重新发送
+ */
+func (client *TdxClient) request(ctx context.Context, reqNode pkg.RequestNode) (pkg.ResponseNode, error) {
+	for {
+		respNode, err := client.roundTrip(ctx, reqNode)
+		if nil == err { return respNode, nil }
 
-	// 深交所中股债基数量
-	hqServer := pkg.GenerateMarketStockCount(0)
-	client.dispatcher.AddHandler(uint32(hqServer.EventId), client.OnStockCount)
-	client.session.Send(hqServer)
+		if err = client.waitReconnect(ctx, err); nil != err { return pkg.ResponseNode{}, err }
+	}
+}
 
-	// 上交所中股债基数量
-	hqServer = pkg.GenerateMarketStockCount(1)
-	client.dispatcher.AddHandler(uint32(hqServer.EventId), client.OnStockCount)
-	client.session.Send(hqServer)
+/**
+ * 通知 Finished 的消费方更新结束, 没有消费方时不阻塞
+ */
+func (client *TdxClient) notifyFinished() {
+	select {
+	case client.Finished <- nil:
+	default:
+	}
+}
+
+/**
+ * 创建记录所有收发封包的协议
+ */
+func (client *TdxClient) newRecordingProtocol(protocol *pkg.TdxPacketProtocolImpl) (*pkg.RecordingProtocol, error) {
+	client.connLock.Lock()
+	defer client.connLock.Unlock()
+
+	// 重连时继续写入同一个记录文件, Close 之后的重连不再重新创建
+	if 1 == atomic.LoadInt32(&client.closed) { return nil, ErrClosed }
+	if nil == client.capture {
+		captureFile, err := os.Create(client.CapturePath)
+		if nil != err { return nil, err }
+
+		capture, err := pkg.NewCaptureWriter(captureFile)
+		if nil != err { captureFile.Close(); return nil, err }
+
+		client.capture = capture
+	}
+
+	return pkg.NewRecordingProtocol(protocol, client.capture), nil
+}
+
+/**
+ * 回放封包记录文件中的应答封包, 返回解析出的股票列表、权息及K线数据
+ * 股票列表、权息及K线的应答默认交给 OnStockBase, OnStockBonus, OnStockHistory 处理,
+ * 其它应答的处理过程需要预先通过 AddHandler 注册
+ */
+func (client *TdxClient) Replay(capturePath string) (*ReplayResult, error) {
+	if nil == client.dispatcher { client.dispatcher = NewCTdxDispatcher() }
+
+	defaultHandlers := map[uint16]func(cnet.ISession, interface{}){
+		pkg.GenerateMarketStockBase(0, 0).EventId:        client.OnStockBase,
+		pkg.GenerateStockBonus(nil, 0).EventId:           client.OnStockBonus,
+		pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId: client.OnStockHistory,
+	}
+	for eventId, handler := range defaultHandlers {
+		if nil == client.dispatcher.GetHandler(uint32(eventId)) { client.AddHandler(eventId, handler) }
+	}
+
+	client.replayed = new(ReplayResult)
+	err := client.dispatcher.Replay(capturePath)
+	return client.replayed, err
+}
+
+/**
+ * 注册应答封包的处理过程, 主要用于回放封包记录, 如 OnStockBase, OnStockBonus, OnStockHistory
+ */
+func (client *TdxClient) AddHandler(eventId uint16, handler func(cnet.ISession, interface{})) {
+	if nil == client.dispatcher { client.dispatcher = NewCTdxDispatcher() }
+	client.dispatcher.AddHandler(uint32(eventId), handler)
+}
+
+/**
+ * 与服务器建立TCP连接, 并完成设备注册及市场信息的初始化
+ */
+func (client *TdxClient) Conn(ctx context.Context) error {
+	atomic.StoreInt32(&client.closed, 0)
+
+	// 有多个候选服务器时先选出最快且数据最新的服务器
+	if 1 < len(client.candidateHosts()) && !client.hostsRanked {
+		if _, err := client.SelectHost(ctx); nil != err { return err }
+	}
+
+	return client.connect(ctx)
+}
+
+/**
+ * 建立连接, 首次连接及自动重连共用
+ * 由当前服务器开始依次尝试所有候选服务器, 直到连接成功
+ */
+func (client *TdxClient) connect(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapInt32(&client.state, int32(StateClosed), int32(StateConnecting)) {
+		return fmt.Errorf("当前连接状态为 %s, 不能重复连接", client.State())
+	}
+	defer func() {
+		if nil != err { atomic.StoreInt32(&client.state, int32(StateClosed)) }
+	}()
+
+	// 重连时沿用原来的分发器, 保留已注册的处理过程
+	if nil == client.dispatcher { client.dispatcher = NewCTdxDispatcher() }
+	if nil == client.metrics { client.metrics = newMetrics(client) }
+	client.dispatcher.onUnknown = client.onUnknownPacket
+	if nil == client.limiter {
+		client.limiter = newRequestLimiter(client.RateLimit, client.MaxInFlight)
+		client.dispatcher.onRelease = client.limiter.release
+	} else {
+		// 沿用原来的发送窗口, 尚未收到应答的请求仍然占用窗口
+		client.limiter.configure(client.RateLimit, client.MaxInFlight)
+	}
+
+	hosts, hostIdx := client.rankedHosts()
+	if 0 == len(hosts) { return fmt.Errorf("没有配置服务器地址") }
+
+	for count := 0; count < len(hosts); count++ {
+		if 0 < count {
+			// 上一个服务器初始化失败时连接状态已被置为 StateClosed
+			if 1 == atomic.LoadInt32(&client.closed) { return ErrClosed }
+			atomic.StoreInt32(&client.state, int32(StateConnecting))
+		}
+
+		idx := (hostIdx + count) % len(hosts)
+		if err = client.connectHost(ctx, hosts[idx]); nil == err {
+			client.setCurrentHost(idx)
+			return nil
+		}
+
+		logger.Error(fmt.Sprintf("连接服务器 %s 失败,err: %v", hosts[idx], err))
+		if nil != ctx.Err() { break }
+	}
+
+	return err
+}
+
+/**
+ * 连接指定的服务器并完成初始化, 最多等待 RequestTimeout
+ */
+func (client *TdxClient) connectHost(ctx context.Context, host string) (err error) {
+	protocol := pkg.NewDefaultProtocol()
+	if 0 < client.MaxBodySize { protocol.MaxBodySize = client.MaxBodySize }
+	protocol.KeepCompressed = 0 < len(client.UnknownPacketPath) || 0 < len(client.CapturePath)
+	client.metrics.connected()
+	metricsRecv, metricsSend := client.metrics.interceptors()
+	protocol.AddInterceptor(metricsRecv)
+	for _, interceptor := range client.Interceptors { protocol.AddInterceptor(interceptor) }
+	protocol.AddInterceptor(metricsSend)
+	var swProtocol cnet.IPacketProtocol = protocol
+
+	if 0 < client.RequestTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.RequestTimeout)
+		defer cancel()
+	}
+
+	if 0 < len(client.CapturePath) {
+		swProtocol, err = client.newRecordingProtocol(protocol)
+		if nil != err {
+			logger.Error(fmt.Sprintf("创建封包记录文件失败,err: %v", err))
+			return err
+		}
+	}
+
+	type dialResult struct {
+		session *cnet.SyncSession
+		err     error
+	}
+	dialChan := make(chan dialResult, 1)
+	go func() {
+		session, err := cnet.NewSyncSession("tcp", host, swProtocol, client.dispatcher.HandleProc, 0)
+		dialChan <- dialResult{session, err}
+	}()
+
+	var session *cnet.SyncSession
+	select {
+	case result := <-dialChan:
+		session, err = result.session, result.err
+	case <-ctx.Done():
+		// 连接迟到时直接关闭
+		go func() { if result := <-dialChan; nil == result.err { result.session.Close() } }()
+		if err = contextError(ctx.Err()); ErrRequestTimeout == err { return ErrConnectTimeout }
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("创建服务器链接失败,err: %v", err)
+	}
+
+	done := newConnDone()
+	session.SetCloseCallback(func(*cnet.Session) { client.onSessionClosed(done) })
+
+	client.connLock.Lock()
+	client.session, client.protocol, client.done = session, protocol, done
+	client.connLock.Unlock()
+
+	session.Start()
+
+	err = client.handshake(ctx)
+	if nil != err {
+		session.Close()
+		if ErrRequestTimeout == err { return ErrConnectTimeout }
+		return err
+	}
+
+	if !atomic.CompareAndSwapInt32(&client.state, int32(StateConnecting), int32(StateReady)) {
+		// 初始化期间被关闭
+		session.Close()
+		return ErrDisconnected
+	}
+
+	if 0 < client.HeartbeatInterval { go client.heartbeat(session, done) }
 
 	// 请求券商公告信息
-	client.session.Send(pkg.GenerateNotice())
+	noticeNode := pkg.GenerateNotice()
+	if nil == protocol.InterceptSend(&noticeNode) { session.Send(noticeNode) }
+	return nil
 }
 
 /**
- * 更新股票基础信息
+ * 注册设备信息并获取市场最后交易信息及股票数量
  */
-func (client *TdxClient) UpdateStockBase(){
-	logger.Info("开始更新深交所股债基列表信息...")
-	stockBase := pkg.GenerateMarketStockBase(0, 0)
-	client.dispatcher.AddHandler(uint32(stockBase.EventId), client.OnStockBase)
+func (client *TdxClient) handshake(ctx context.Context) error {
+	// 注册设备信息
+	respNode, err := client.roundTrip(ctx, pkg.GenerateDeviceNode(client.MainVersion, client.CoreVersion))
+	if nil != err { return err }
+	UnknownPkgHandler(client.session, respNode)
 
-	for idx := 0;uint32(idx) < client.lastTrade.SZCount; idx += 0x03E8 {
-		stockBase = pkg.GenerateMarketStockBase(0, uint16(idx))
-		client.session.Send(stockBase)
+	// 设置市场最后交易信息
+	respNode, err = client.roundTrip(ctx, pkg.GenerateMarketInitInfo())
+	if nil != err { return err }
+	client.OnMarketInitInfo(client.session, respNode)
+
+	// 深交所及上交所中股债基数量
+	for market := 0; market < 2; market++ {
+		respNode, err = client.roundTrip(ctx, pkg.GenerateMarketStockCount(market))
+		if nil != err { return err }
+		client.OnStockCount(client.session, respNode)
 	}
 
+	return nil
+}
+
+/**
+ * 更新股票基础信息
+ */
+func (client *TdxClient) UpdateStockBase(ctx context.Context) error {
+	logger.Info("开始更新深交所股债基列表信息...")
+	szList, err := client.GetStockList(ctx, 0)
+	if nil != err { return err }
+
 	logger.Info("开始更新上交所股债基列表信息...")
-	// 更新上交所股债基列表信息
-	for idx := 0;uint32(idx) < client.lastTrade.SHCount; idx += 0x03E8 {
-		stockBase = pkg.GenerateMarketStockBase(1, uint16(idx))
-		client.session.Send(stockBase)
+	shList, err := client.GetStockList(ctx, 1)
+	if nil != err { return err }
+
+	stockBaseDF := dataframe.LoadStructs(append(szList, shList...))
+	if nil != stockBaseDF.Err {
+		return fmt.Errorf("加载新的股票数据时发生错误: %v", stockBaseDF.Err)
 	}
+
+	client.stockBaseDF = stockBaseDF
+	client.saveStockBase()
+	client.onSTStocks()
+	client.notifyFinished()
+	return nil
 }
 
-func  (client *TdxClient)updateBonus(df *dataframe.DataFrame){
-	// 筛选掉已经处理过的数据
-    var finishedCode string
+func  (client *TdxClient)updateBonus(ctx context.Context, df *dataframe.DataFrame) error {
 	var row map[string]interface{}
-	var stockBonus []pkg.StockBonus
     logger.Info("开始接收高送转数据...")
 	for _, row = range df.Maps() {
         var code [6]byte
         market := byte(row["market"].(int))
         strCode := row["code"].(string)
         copy(code[:], []byte(strCode))
-        bonusItem := pkg.StockBonus{market, code}
-        stockBonus = append(stockBonus, bonusItem)
-		reqNode := pkg.GenerateStockBonus(stockBonus, 0)
-		client.session.Send(reqNode)
-		finishedCode =<-client.bonusFinishedChan
-        logger.Info("%s 接受完成", finishedCode)
-        stockBonus = []pkg.StockBonus{}
-	}
-	reqNode := pkg.GenerateStockBonus(stockBonus, stockBonusFinishedIdx)
-	client.session.Send(reqNode)
+		respNode, err := client.request(ctx, pkg.GenerateStockBonus([]pkg.StockBonus{{market, code}}, 0))
+		if nil != err { return err }
+
+		client.onStockBonus(respNode)
+        logger.Info("%s 接受完成", strCode)
+	}
+
 	logger.Info("高送转数据接收完毕...")
+	return nil
 }
 
 /**
  * 更新股票高送转数据
  */
-func (client *TdxClient) UpdateStockBonus(){
+func (client *TdxClient) UpdateStockBonus(ctx context.Context) error {
 	// 股指基
 	df := comm.GetFinanceDataFrame(client.Configure, comm.STOCKA, comm.STOCKB, comm.INDEX, comm.FUNDS, comm.INDUSTRY)
 	if nil != df.Err {
-		logger.Error(fmt.Sprintf("读取股票基础数据失败! err:%v", df))
-		return
+		return fmt.Errorf("读取股票基础数据失败! err:%v", df.Err)
 	}
 
-	client.bonusFinishedChan = make(chan string)
-	stockBonus := pkg.GenerateStockBonus(nil, 0)
-	client.dispatcher.AddHandler(uint32(stockBonus.EventId), client.OnStockBonus)
-
 	filterDf := df.Filter(dataframe.F{"bonus2", series.Greater, 0})
 
-	client.updateBonus(&filterDf)
+	err := client.updateBonus(ctx, &filterDf)
+	if nil != err { return err }
+
+	client.saveStockBonus()
+	client.notifyFinished()
+	return nil
 }
 
 /**
  * 更新股票日线数据
  */
-func (client *TdxClient) UpdateDays(){
+func (client *TdxClient) UpdateDays(ctx context.Context) error {
+	return client.UpdateBars(ctx, pkg.PeriodDay)
+}
+
+/**
+ * 更新股票五分钟线数据
+ */
+func (client *TdxClient) UpdateMins(ctx context.Context) error {
+	return client.UpdateBars(ctx, pkg.PeriodMin5)
+}
+
+/**
+ * 各周期K线数据的存放目录
+ */
+func (client *TdxClient) barsDir(period pkg.KLinePeriod) string {
+	files := client.Configure.GetTdx().Files
+	switch period {
+	case pkg.PeriodMin1: return files.StockMin1
+	case pkg.PeriodMin5: return files.StockMin
+	case pkg.PeriodMin15: return files.StockMin15
+	case pkg.PeriodMin30: return files.StockMin30
+	case pkg.PeriodMin60: return files.StockMin60
+	case pkg.PeriodWeek: return files.StockWeek
+	case pkg.PeriodMonth: return files.StockMonth
+	case pkg.PeriodQuarter: return files.StockQuarter
+	case pkg.PeriodYear: return files.StockYear
+	}
+	return files.StockDay
+}
+
+/**
+ * 分钟线单次请求的自然日跨度, 保持每次请求的K线数量与五分钟线(15天)接近
+ */
+func minsRequestDays(period pkg.KLinePeriod) int {
+	switch period {
+	case pkg.PeriodMin1: return 0x03
+	case pkg.PeriodMin15: return 0x2D
+	case pkg.PeriodMin30: return 0x5A
+	case pkg.PeriodMin60: return 0xB4
+	}
+	return 0x0F
+}
+
+/**
+ * 周线及以上周期中 date(yyyymmdd) 所在周期的第一天, 日线及分钟线返回 date 本身
+ * 如: 周线 20180105 => 20180101, 季线 20180515 => 20180401
+ */
+func periodStartDate(period pkg.KLinePeriod, date int) int {
+	year, month, day := date/10000, date/100%100, date%100
+	switch period {
+	case pkg.PeriodWeek:
+		weekDay := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+		monday := weekDay.AddDate(0, 0, -(int(weekDay.Weekday())+6)%7)
+		return monday.Year()*10000 + int(monday.Month())*100 + monday.Day()
+	case pkg.PeriodMonth: return year*10000 + month*100 + 1
+	case pkg.PeriodQuarter: return year*10000 + ((month-1)/3*3+1)*100 + 1
+	case pkg.PeriodYear: return year*10000 + 101
+	}
+	return date
+}
+
+/**
+ * 更新指定周期的K线数据
+ */
+func (client *TdxClient) UpdateBars(ctx context.Context, period pkg.KLinePeriod) error {
+	stockBaseDF, securities, err := barsSecurities(client.Configure, period)
+	client.stockBaseDF = stockBaseDF
+	if nil != err { return err }
+
+	if err = client.updateBars(ctx, period, securities); nil != err { return err }
+
+	client.notifyFinished()
+	return nil
+}
+
+/**
+ * 需要更新K线数据的证券代码(市场+代码, 如: 1600000), 包含股票、指数及基金(日线及以上周期另含行业指数)
+ * 同时返回对应的股票基础数据, 不修改任何连接的状态, 可供连接池使用
+ */
+func barsSecurities(conf comm.IConfigure, period pkg.KLinePeriod) (dataframe.DataFrame, []string, error) {
+	var securities []string
+	var stockBaseDF dataframe.DataFrame
+
+	// 股指基(分钟线不包含行业指数)
+	if period.IsMinute() {
+		stockBaseDF = comm.GetFinanceDataFrame(conf, comm.STOCKA, comm.STOCKB, comm.INDEX, comm.FUNDS)
+	} else {
+		stockBaseDF = comm.GetFinanceDataFrame(conf, comm.STOCKA, comm.STOCKB, comm.INDEX, comm.FUNDS, comm.INDUSTRY)
+	}
+	if nil != stockBaseDF.Err {
+		return stockBaseDF, nil, fmt.Errorf("读取股票基础数据失败! err:%v", stockBaseDF.Err)
+	}
+
+	for _, row := range stockBaseDF.Maps() {
+		securities = append(securities, fmt.Sprintf("%d%s", row["market"].(int), row["code"].(string)))
+	}
+
+	return stockBaseDF, securities, nil
+}
+
+/**
+ * 更新K线数据时最多同时等待应答的请求数, 与发送窗口一致, 不限制发送窗口时为 defaultMaxInFlight
+ */
+func (client *TdxClient) barsWindowSize() int {
+	if 0 < client.MaxInFlight { return client.MaxInFlight }
+	return defaultMaxInFlight
+}
+
+/**
+ * 更新指定证券的K线数据, 同时等待应答的请求最多 barsWindowSize 个
+ */
+func (client *TdxClient) updateBars(ctx context.Context, period pkg.KLinePeriod, securities []string) (err error) {
+	type barsRequest struct {
+		reqNode  pkg.RequestNode
+		callback func(pkg.ResponseNode)
+		future   *Future
+		err      error  // 解析或保存应答时发生的错误, 在收到应答的回调中设置
+	}
+	var pendingList []*barsRequest  // 已发出但尚未收到应答的请求
+
 	defer func() {
 		if p := recover(); p != nil {
 			fmt.Printf("panic recover! p: %v", p)
+			err = fmt.Errorf("更新K线数据出错: %v", p)
 		}
 
-		today, _ := strconv.Atoi(utils.Today())
-
-		reqNode := pkg.GenerateStockDayItem(0, "000001", uint32(today), uint32(today), uint16(0xffff)) // index要避免是0，0的话会随机生成idx
-		client.session.Send(reqNode)
+		// 出错时放弃等待剩余的应答
+		for _, item := range pendingList {
+			if nil != item.future { client.dispatcher.Cancel(item.future) }
+		}
 	}()
 
-	calendar, err := comm.DefaultStockCalendar("")
-	if nil != err { logger.Error(fmt.Sprintf("UpdateDays Err:%v", err)); return }
+	// 连接断开时等待自动重连, 重连成功后重新发送尚未收到应答的请求
+	resume := func(err error) error {
+		for nil != err {
+			if err = client.waitReconnect(ctx, err); nil != err { return err }
 
-	// 股指基
-	client.stockBaseDF = comm.GetFinanceDataFrame(client.Configure, comm.STOCKA, comm.STOCKB, comm.INDEX, comm.FUNDS, comm.INDUSTRY)
-	if nil != client.stockBaseDF.Err {
-		logger.Error(fmt.Sprintf("读取股票基础数据失败! err:%v", client.stockBaseDF))
-		return
+			for _, item := range pendingList {
+				if nil != item.future {
+					if 0 < len(item.future.Done()) { continue }
+					client.dispatcher.Cancel(item.future)
+				}
+
+				if item.future, err = client.call(ctx, item.reqNode, item.callback); nil != err { break }
+			}
+		}
+		return nil
 	}
 
+	// 应答按请求的顺序到达, 等待最早的请求即可限制同时等待应答的请求数
+	waitOldest := func() error {
+		for {
+			_, err := client.wait(ctx, pendingList[0].future)
+			if nil == err {
+				oldest := pendingList[0]
+				pendingList = pendingList[1:]
+				return oldest.err
+			}
+
+			if err = resume(err); nil != err { return err }
+		}
+	}
+
+	calendar, err := comm.DefaultStockCalendar("")
+	if nil != err { return fmt.Errorf("UpdateBars Err:%v", err) }
+
 	today, _ := strconv.Atoi(utils.Today())
 
-	dayItem := pkg.GenerateStockDayItem(0, "", 0, 0, 0)
-	client.dispatcher.AddHandler(uint32(dayItem.EventId), client.OnStockHistory)
+	colTypes := map[string]series.Type{
+		"market": series.Int, "code": series.String, "date": series.Int, "open": series.Float, "low": series.Float,
+		"high": series.Float, "close": series.Float, "volume": series.Int, "amount": series.Float}
+	if period.IsMinute() { colTypes["time"] = series.String }
 
-	for idx, row := range client.stockBaseDF.Maps() {
-		var code [6]byte
-		market := row["market"].(int)
-		strCode := row["code"].(string)
-		logger.Info("接收 %d%s 的日线数据...", market, strCode)
+	for _, security := range securities {
+		stockItem, err := parseSecurityCode(security)
+		if nil != err { return err }
 
-		copy(code[:], []byte(strCode))
+		market := int(stockItem.Market)
+		strCode := string(stockItem.Code[:])
+		logger.Info("接收 %d%s 的K线(周期:%d)数据...", market, strCode, period)
 
+		// 日线及以上周期从头开始, 分钟线默认由今天往前100天
 		start := "19901219"
-		fileName := fmt.Sprintf("%d%s.csv", market, strCode)
-
-		stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath,
-			client.Configure.GetTdx().Files.StockDay, fileName)
+		if period.IsMinute() { start = utils.AddDays(utils.Today(), -100) }
 
-		colTypes := map[string]series.Type{
-			"market": series.Int, "code": series.String, "date": series.Int, "open": series.Float, "low": series.Float,
-			"high": series.Float, "close": series.Float, "volume": series.Int, "amount": series.Float}
+		fileName := fmt.Sprintf("%d%s.csv", market, strCode)
+		stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath, client.barsDir(period), fileName)
 
 		stockItemDF := utils.ReadCSV(stocksPath, dataframe.WithTypes(colTypes))
 
+		if nil == stockItemDF.Err && !period.IsMinute() && comm.IsIndex(market, strCode) &&
+			0 > utils.FindInStringSlice("up", stockItemDF.Names()) {
+			// 旧格式的指数数据中没有涨跌家数, 补上空的涨跌家数后继续增量更新
+			logger.Info("%d%s 的指数数据缺少涨跌家数, 补充空的涨跌家数...", market, strCode)
+			stockItemDF, err = client.migrateIndexBars(stocksPath, stockItemDF)
+			if nil != err { return fmt.Errorf("UpdateBars Err:%v", err) }
+		}
+
 		if nil == stockItemDF.Err {
 			// 获取最后一条记录的日期
 			idx := utils.FindInStringSlice("date", stockItemDF.Names())
-			start, err = calendar.NextDay(stockItemDF.Elem(stockItemDF.Nrow()-1, idx).String())
-			if nil != err {
-				logger.Error(fmt.Sprintf("UpdateDays Err:%v", err))
-				return
+			lastDate := stockItemDF.Elem(stockItemDF.Nrow()-1, idx).String()
+
+			nextDays, err := calendar.NextDay(lastDate)
+			if nil != err { return fmt.Errorf("UpdateBars Err:%v", err) }
+
+			if !period.IsMinute() && pkg.PeriodDay != period {
+				// 最后一根K线可能是尚未结束的周期, 去掉后从该周期的第一天重新下载
+				if err = client.dropLastBar(stocksPath, stockItemDF); nil != err { return fmt.Errorf("UpdateBars Err:%v", err) }
+				date, _ := strconv.Atoi(lastDate)
+				nextDays = strconv.Itoa(periodStartDate(period, date))
 			}
+			if strings.Compare(nextDays, start) > 0 { start = nextDays }
 		}
 
 		tmpStart, _ := strconv.Atoi(start)
 
 		for tmpEnd:=0; tmpEnd < today;  {
-			if tmpStart+40000 > today {
-				tmpEnd = today
+			if period.IsMinute() {
+				resultDate := utils.AddDaysExceptWeekend(fmt.Sprintf("%d", tmpStart), minsRequestDays(period))
+				tmpEnd, _ = strconv.Atoi(resultDate)
 			} else {
 				tmpEnd = tmpStart+40000
 			}
+			if tmpEnd > today { tmpEnd = today }
+
+			if len(pendingList) >= client.barsWindowSize() {
+				if err = waitOldest(); nil != err { return err }
+			}
 
-			reqNode := pkg.GenerateStockDayItem(uint16(market), strCode, uint32(tmpStart), uint32(tmpEnd), uint16(idx+1)) // index要避免是0，0的话会随机生成idx
-			client.session.Send(reqNode)
+			item := &barsRequest{reqNode: pkg.GenerateStockBarsItem(period, uint16(market), strCode, uint32(tmpStart), uint32(tmpEnd), 0)}
+			item.callback = func(respNode pkg.ResponseNode) { item.err = client.onStockHistory(period, market, strCode, respNode) }
+			item.future, err = client.call(ctx, item.reqNode, item.callback)
+			pendingList = append(pendingList, item)
+			if err = resume(err); nil != err { return err }
 
-			tmpStart = tmpEnd+1
+			if period.IsMinute() {
+				nextEnd, _ := calendar.NextDay(strconv.Itoa(tmpEnd))
+				tmpStart, _ = strconv.Atoi(nextEnd)
+			} else {
+				tmpStart = tmpEnd+1
+			}
 		}
 	}
+
+	for 0 < len(pendingList) {
+		if err = waitOldest(); nil != err { return err }
+	}
+
+	return nil
 }
 
 /**
- * 更新股票五分钟线数据
+ * 解析 市场标识+股票代码 形式的证券代码, 如: 0000001(深), 1600000(沪)
  */
-func (client *TdxClient) UpdateMins(){
-	defer func() {
-		if p := recover(); p != nil {
-			fmt.Printf("panic recover! p: %v", p)
-		}
+func parseSecurityCode(strCode string) (pkg.SecurityItem, error) {
+	var item pkg.SecurityItem
+	if 7 != len(strCode) || (strCode[0] != '0' && strCode[0] != '1') {
+		return item, fmt.Errorf("无效的证券代码: %s", strCode)
+	}
 
-		today, _ := strconv.Atoi(utils.Today())
+	item.Market = strCode[0] - '0'
+	copy(item.Code[:], []byte(strCode[1:]))
+	return item, nil
+}
 
-		// 通知消费方更新结束
-		reqNode := pkg.GenerateStockMinsItem(0, "000001", uint32(today), uint32(today), uint16(0xffff))
-		client.session.Send(reqNode)
-	}()
+/**
+ * 获取股票列表, 不写入任何文件
+ * market: 0深交所, 1上交所
+ */
+func (client *TdxClient) GetStockList(ctx context.Context, market int) ([]StockBaseModel, error) {
+	var stockList []StockBaseModel
 
-	calendar, err := comm.DefaultStockCalendar("")
-	if nil != err { logger.Error(fmt.Sprintf("UpdateMins Err:%v", err)); return }
+	for start := 0; ; start += stockBaseBatchSize {
+		respNode, err := client.request(ctx, pkg.GenerateMarketStockBase(uint16(market), uint16(start)))
+		if nil != err { return nil, err }
 
-	// 股指基
-    client.stockBaseDF = comm.GetFinanceDataFrame(client.Configure, comm.STOCKA, comm.STOCKB, comm.INDEX, comm.FUNDS)
-	if nil != client.stockBaseDF.Err {
-		logger.Error(fmt.Sprintf("读取股票基础数据失败! err:%v", client.stockBaseDF))
-		return
+		pageList, err := decodeStockBase(market, respNode.RawData.([]byte))
+		if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析股票列表出错: %v", err) }
+
+		stockList = append(stockList, pageList...)
+		if len(pageList) < stockBaseBatchSize { break }
 	}
 
-	today, _ := strconv.Atoi(utils.Today())
-	minItem := pkg.GenerateStockMinsItem(0, "", 0, 0, 0)
-    client.dispatcher.AddHandler(uint32(minItem.EventId), client.OnStockHistory)
+	return stockList, nil
+}
 
-	for idx, row := range client.stockBaseDF.Maps() {
-		var code [6]byte
-		market := row["market"].(int)
-		strCode := row["code"].(string)
-		logger.Info("接收 %d%s 的五分钟线数据...", market, strCode)
+/**
+ * 获取一批证券的权息数据, 不写入任何文件
+ * codes: 市场标识+股票代码, 如: 0000001(深), 1600000(沪)
+ */
+func (client *TdxClient) GetBonus(ctx context.Context, codes []string) ([]StockBonusModel, error) {
+	var bonusList []StockBonusModel
 
-		copy(code[:], []byte(strCode))
+	for _, strCode := range codes {
+		item, err := parseSecurityCode(strCode)
+		if nil != err { return nil, err }
 
-		// 默认由今天往前100天
-		start := utils.AddDays(utils.Today(), -100)
-		fileName := fmt.Sprintf("%d%s.csv", market, strCode)
+		respNode, err := client.request(ctx, pkg.GenerateStockBonus([]pkg.StockBonus{{item.Market, item.Code}}, 0))
+		if nil != err { return nil, err }
 
-		stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath,
-			client.Configure.GetTdx().Files.StockMin, fileName)
+		stockBonusList, err := decodeStockBonus(respNode.RawData.([]byte))
+		if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析 %s 的权息数据出错: %v", strCode, err) }
 
-		colTypes := map[string]series.Type{
-			"market": series.Int, "code": series.String, "date": series.Int, "time": series.String,
-			"open": series.Float, "low": series.Float, "high": series.Float, "close": series.Float,
-			"volume": series.Int, "amount": series.Float}
+		bonusList = append(bonusList, stockBonusList...)
+	}
 
-		stockItemDF := utils.ReadCSV(stocksPath, dataframe.WithTypes(colTypes))
+	return bonusList, nil
+}
 
-		if nil == stockItemDF.Err {
-			// 获取最后一条记录的日期
-			nextDays := start
-			idx := utils.FindInStringSlice("date", stockItemDF.Names())
-			nextDays, err = calendar.NextDay(stockItemDF.Elem(stockItemDF.Nrow()-1, idx).String())
-			if nil != err { logger.Error(fmt.Sprintf("UpdateMins Err:%v", err)); return }
-			if strings.Compare(nextDays, start) > 0  { start = nextDays }
-		}
+/**
+ * 获取日线数据, 不写入任何文件
+ * start, end: yyyymmdd
+ */
+func (client *TdxClient) GetDayBars(ctx context.Context, market int, code string, start, end int) ([]StockDayModel, error) {
+	var dayList []StockDayModel
 
-		tmpStart, _ := strconv.Atoi(start)
-		for tmpEnd:=0; tmpEnd < today;  {
-			resultDate := utils.AddDaysExceptWeekend(fmt.Sprintf("%d", tmpStart), 0x0F)
-			nResultDate, _ := strconv.Atoi(resultDate)
+	if start > end { return nil, fmt.Errorf("无效的日期区间: %d~%d", start, end) }
 
-			if nResultDate > today { tmpEnd = today } else { tmpEnd = nResultDate }
+	for tmpStart := start; tmpStart <= end; {
+		tmpEnd := tmpStart+40000
+		if tmpEnd > end { tmpEnd = end }
 
-			reqNode := pkg.GenerateStockMinsItem(uint16(market), strCode, uint32(tmpStart), uint32(tmpEnd), uint16(idx+1))
-			client.session.Send(reqNode)
+		reqNode := pkg.GenerateStockDayItem(uint16(market), code, uint32(tmpStart), uint32(tmpEnd), 0)
+		respNode, err := client.request(ctx, reqNode)
+		if nil != err { return nil, err }
 
-			nextEnd, _ := calendar.NextDay(strconv.Itoa(tmpEnd))
-			tmpStart, _ = strconv.Atoi(nextEnd)
-		}
+		pageList, err := decodeStockDays(market, code, respNode.RawData.([]byte))
+		if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析 %d%s 的日线数据出错: %v", market, code, err) }
+
+		dayList = append(dayList, pageList...)
+		tmpStart = tmpEnd+1
+	}
+
+	return dayList, nil
+}
+
+/**
+ * 获取一批证券的实时行情快照
+ * codes: 市场标识+股票代码, 如: 0000001(深), 1600000(沪)
+ */
+func (client *TdxClient) GetQuotes(ctx context.Context, codes []string) ([]QuoteModel, error) {
+	var quoteList []QuoteModel
+	var stocks []pkg.SecurityItem
+
+	for _, strCode := range codes {
+		item, err := parseSecurityCode(strCode)
+		if nil != err { return nil, err }
+		stocks = append(stocks, item)
+	}
+
+	for start := 0; start < len(stocks); start += quotesBatchSize {
+		end := start + quotesBatchSize
+		if end > len(stocks) { end = len(stocks) }
+
+		respNode, err := client.request(ctx, pkg.GenerateSecurityQuotes(stocks[start:end]))
+		if nil != err { return nil, err }
+
+		batchList, err := decodeSecurityQuotes(respNode.RawData.([]byte))
+		if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析实时行情数据出错: %v", err) }
+
+		quoteList = append(quoteList, batchList...)
+	}
+
+	return quoteList, nil
+}
+
+/**
+ * 获取分笔成交数据
+ * date: yyyymmdd, 为0时获取当日的分笔成交
+ * start: 由最新一笔往前计算的偏移量
+ * count: 获取的数量, 单次最多2000笔
+ */
+func (client *TdxClient) GetTransactions(ctx context.Context, market int, code string, date, start, count int) ([]StockTickModel, error) {
+	if count <= 0 || count > ticksBatchSize {
+		return nil, fmt.Errorf("无效的分笔数量: %d", count)
+	}
+
+	var reqNode pkg.RequestNode
+	isHistory := 0 != date
+	if !isHistory {
+		reqNode = pkg.GenerateTransaction(uint16(market), code, uint16(start), uint16(count))
+		date, _ = strconv.Atoi(utils.Today())
+	} else {
+		reqNode = pkg.GenerateHistoryTransaction(uint16(market), code, uint32(date), uint16(start), uint16(count))
+	}
+
+	respNode, err := client.request(ctx, reqNode)
+	if nil != err { return nil, err }
+
+	tickList, err := decodeTransaction(respNode.RawData.([]byte), isHistory)
+	if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析分笔成交数据出错: %v", err) }
+
+	for idx := range tickList {
+		tickList[idx].Market = market
+		tickList[idx].Code = code
+		tickList[idx].Date = date
+	}
+
+	return tickList, nil
+}
+
+/**
+ * 按偏移量分页获取某天完整的分笔成交数据
+ * date: yyyymmdd, 为0时获取当日的分笔成交
+ */
+func (client *TdxClient) GetDayTransactions(ctx context.Context, market int, code string, date int) ([]StockTickModel, error) {
+	var tickList []StockTickModel
+
+	for start := 0; ; start += ticksBatchSize {
+		pageList, err := client.GetTransactions(ctx, market, code, date, start, ticksBatchSize)
+		if nil != err { return nil, err }
+
+		// 偏移量由最新一笔往前计算, 因此较早的数据放在前面
+		tickList = append(pageList, tickList...)
+		if len(pageList) < ticksBatchSize { break }
 	}
+
+	return tickList, nil
 }
 
 /**
- * 更新财报信息
+ * 获取分时数据
+ * date: yyyymmdd, 为0时获取当日的分时数据
  */
-func (client *TdxClient) UpdateReport(){
+func (client *TdxClient) GetMinuteTimeShare(ctx context.Context, market int, code string, date int) ([]MinuteTimeModel, error) {
+	var reqNode pkg.RequestNode
+	isHistory := 0 != date
+	if !isHistory {
+		reqNode = pkg.GenerateMinuteTimeShare(uint16(market), code)
+		date, _ = strconv.Atoi(utils.Today())
+	} else {
+		reqNode = pkg.GenerateHistoryMinuteTimeShare(byte(market), code, uint32(date))
+	}
+
+	respNode, err := client.request(ctx, reqNode)
+	if nil != err { return nil, err }
+
+	minuteList, err := decodeMinuteTimeShare(respNode.RawData.([]byte), isHistory)
+	if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析分时数据出错: %v", err) }
+	if 0 >= len(minuteList) {
+		return nil, fmt.Errorf("没有 %d%s 在 %d 的分时数据", market, code, date)
+	}
+
+	for idx := range minuteList {
+		minuteList[idx].Market = market
+		minuteList[idx].Code = code
+		minuteList[idx].Date = date
+	}
+
+	return minuteList, nil
+}
+
+/**
+ * 获取F10资料的目录
+ */
+func (client *TdxClient) GetCompanyInfoCategories(ctx context.Context, market int, code string) ([]CompanyInfoCategoryModel, error) {
+	reqNode := pkg.GenerateCompanyInfoCategory(uint16(market), code)
+
+	respNode, err := client.request(ctx, reqNode)
+	if nil != err { return nil, err }
+
+	categoryList, err := decodeCompanyInfoCategory(respNode.RawData.([]byte))
+	if nil != err { client.metrics.decodeFailed(); return nil, fmt.Errorf("解析F10资料目录出错: %v", err) }
+	if 0 >= len(categoryList) {
+		return nil, fmt.Errorf("没有 %d%s 的F10资料", market, code)
+	}
+
+	return categoryList, nil
+}
+
+/**
+ * 获取F10资料中某一目录的内容
+ * fileName, start, length 取自 GetCompanyInfoCategories 返回的目录
+ */
+func (client *TdxClient) GetCompanyInfoContent(ctx context.Context, market int, code string, fileName string, start, length int) (string, error) {
+	reqNode := pkg.GenerateCompanyInfoContent(uint16(market), code, fileName, uint32(start), uint32(length))
+
+	respNode, err := client.request(ctx, reqNode)
+	if nil != err { return "", err }
+
+	content, err := decodeCompanyInfoContent(respNode.RawData.([]byte))
+	if nil != err { client.metrics.decodeFailed(); return "", fmt.Errorf("解析F10资料内容出错: %v", err) }
+	if 0 >= len(content) {
+		return "", fmt.Errorf("获取 %d%s 的F10资料 %s 失败", market, code, fileName)
+	}
+
+	return content, nil
+}
+
+/**
+ * 通过行情连接下载服务器上的文件(行业配置、板块文件等)并保存到dest
+ * name: 如 tdxhy.cfg, tdxzs.cfg, block_zs.dat 等
+ */
+func (client *TdxClient) DownloadFile(ctx context.Context, name, dest string) error {
+	respNode, err := client.request(ctx, pkg.GenerateFileMeta(name))
+	if nil != err { return err }
+
+	fileMeta, err := decodeFileMeta(respNode.RawData.([]byte))
+	if nil != err { client.metrics.decodeFailed(); return fmt.Errorf("解析文件 %s 的元信息出错: %v", name, err) }
+	if 0 >= fileMeta.Size {
+		return fmt.Errorf("服务器上不存在文件 %s", name)
+	}
+
+	var content []byte
+	for offset := uint32(0); offset < fileMeta.Size; {
+		respNode, err = client.request(ctx, pkg.GenerateFileChunk(name, offset, fileChunkSize))
+		if nil != err { return err }
+
+		chunkData, err := decodeFileChunk(respNode.RawData.([]byte))
+		if nil != err { client.metrics.decodeFailed(); return fmt.Errorf("解析文件 %s 的分块内容出错: %v", name, err) }
+		if 0 >= len(chunkData) { break }
+
+		content = append(content, chunkData...)
+		offset += uint32(len(chunkData))
+	}
+
+	if uint32(len(content)) != fileMeta.Size {
+		return fmt.Errorf("文件 %s 下载不完整, 期望长度:%d, 实际长度:%d", name, fileMeta.Size, len(content))
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if nil != err { return err }
+
+	return ioutil.WriteFile(dest, content, 0666)
+}
+
+/**
+ * 更新板块文件
+ */
+func (client *TdxClient) UpdateBlocks(ctx context.Context) error {
+	blockDir := fmt.Sprintf("%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockBlock)
+
+	// 部分文件更新失败时也要重新载入
+	defer comm.ResetStockBlocks()
+
+	for fileName := range comm.BlockFiles {
+		logger.Info("更新板块文件 %s ...", fileName)
+		err := client.DownloadFile(ctx, fileName, fmt.Sprintf("%s%s", blockDir, fileName))
+		if nil != err { return err }
+	}
+
+	return nil
+}
+
+/**
+ * 更新行业分类文件(tdxhy.cfg, tdxzs.cfg)
+ */
+func (client *TdxClient) UpdateIndustry(ctx context.Context) error {
+	files := client.Configure.GetTdx().Files
+	dataPath := client.Configure.GetApp().DataPath
+
+	// 部分文件更新失败时也要重新载入
+	defer comm.ResetStockIndustry()
+
+	logger.Info("更新行业分类文件 ...")
+	err := client.DownloadFile(ctx, "tdxhy.cfg", fmt.Sprintf("%s%s", dataPath, files.IndustryList))
+	if nil != err { return err }
+
+	return client.DownloadFile(ctx, "tdxzs.cfg", fmt.Sprintf("%s%s", dataPath, files.IndustryIndex))
+}
+
+/**
+ * 更新财报信息, 财报文件通过HTTP逐个下载, ctx 在每个文件下载前检查
+ */
+func (client *TdxClient) UpdateReport(ctx context.Context) error {
 	var noneTotal int
 
 	reportUrl := fmt.Sprintf("%s/%s", client.Configure.GetTdx().Urls.StockFin, client.Configure.GetTdx().Urls.FinListFile)
@@ -346,6 +1186,12 @@ func (client *TdxClient) UpdateReport(){
 			continue
 		}
 
+		select {
+		case <-ctx.Done():
+			return contextError(ctx.Err())
+		default:
+		}
+
 		logger.Info(fmt.Sprintf("更新财报文件 %s ... ", fileName))
 		err := os.Remove(filePath)
 		if nil != err {
@@ -353,13 +1199,15 @@ func (client *TdxClient) UpdateReport(){
 			if ! ok {
 				// 处理非文件不存在的错误
 				logger.Error(fmt.Sprintf("删除旧财报文件 `%s` 失败, Err: %v", fileName, pathErr))
-				return
+				return err
 			}
 		}
 
 		reportUrl = fmt.Sprintf("%s/%s", client.Configure.GetTdx().Urls.StockFin, fileName)
 		content := cnet.HttpRequest(reportUrl, "", "", "", "")
 		err = ioutil.WriteFile(filePath, content, 0666)
-		if nil != err { logger.Error(fmt.Sprintf("更新财报文件 `%s` 失败, Err: %v", fileName, err)); return }
+		if nil != err { logger.Error(fmt.Sprintf("更新财报文件 `%s` 失败, Err: %v", fileName, err)); return err }
 	}
+
+	return nil
 }
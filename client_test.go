@@ -0,0 +1,481 @@
+package ctdx
+
+import (
+	"os"
+	"net"
+	"time"
+	"sync"
+	"strings"
+	"sync/atomic"
+	"io/ioutil"
+	"path/filepath"
+	"context"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/ctdx/comm"
+	"github.com/datochan/ctdx/tdxtest"
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+func newTestClient(dataHost string) *TdxClient {
+	configure := new(comm.Conf)
+	configure.Tdx.Server.DataHost = dataHost
+	return NewDefaultTdxClient(configure)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试连接失败时返回错误", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		dataHost := listener.Addr().String()
+		listener.Close()
+
+		tdxClient := newTestClient(dataHost)
+		So(tdxClient.Conn(ctx), ShouldNotBeNil)
+		So(tdxClient.State(), ShouldEqual, StateClosed)
+
+		_, err = tdxClient.GetStockList(ctx, 0)
+		So(err, ShouldEqual, ErrNotConnected)
+	})
+
+	Convey("测试通过模拟服务器查询数据", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		So(tdxClient.State(), ShouldEqual, StateReady)
+		So(tdxClient.GetLastTradeDate(), ShouldEqual, 20180105)
+		So(tdxClient.Conn(ctx), ShouldNotBeNil)
+
+		stockList, err := tdxClient.GetStockList(ctx, 1)
+		So(err, ShouldBeNil)
+		So(stockList, ShouldHaveLength, 2)
+		So(stockList[0].Code, ShouldEqual, "600000")
+
+		dayList, err := tdxClient.GetDayBars(ctx, 1, "600000", 20180103, 20180105)
+		So(err, ShouldBeNil)
+		So(dayList, ShouldHaveLength, 3)
+		So(dayList[0].Date, ShouldEqual, 20180103)
+
+		bonusList, err := tdxClient.GetBonus(ctx, []string{"0000001"})
+		So(err, ShouldBeNil)
+		So(bonusList, ShouldHaveLength, 1)
+		So(bonusList[0].Date, ShouldEqual, 20170713)
+
+		Convey("测试分时数据", func() {
+			fixtureList := tdxtest.NewFixture().MinuteTimes["1600000"]
+
+			for _, date := range []int{0, 20180105} {
+				minuteList, err := tdxClient.GetMinuteTimeShare(ctx, 1, "600000", date)
+				So(err, ShouldBeNil)
+				So(minuteList, ShouldHaveLength, len(fixtureList))
+
+				for idx, minute := range minuteList {
+					So(minute.Time, ShouldEqual, minuteTimeShareTime(idx))
+					So(minute.Price, ShouldAlmostEqual, float64(fixtureList[idx].Price)/100.0)
+					So(minute.AvgPrice, ShouldAlmostEqual, float64(fixtureList[idx].AvgPrice)/100.0)
+					So(minute.Volume, ShouldEqual, fixtureList[idx].Volume)
+				}
+			}
+
+			_, err := decodeMinuteTimeShare([]byte{0x01, 0x00, 0x00}, false)
+			So(err, ShouldNotBeNil)
+			_, err = decodeTransaction([]byte{0x01, 0x00, 0x00, 0x00}, true)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("测试请求超时", func() {
+			server.SetDelay(200 * time.Millisecond)
+			defer server.SetDelay(0)
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+
+			_, err := tdxClient.GetStockList(timeoutCtx, 0)
+			So(err, ShouldEqual, ErrRequestTimeout)
+			So(tdxClient.dispatcher.Pending(), ShouldEqual, 0)
+		})
+
+		Convey("测试无法解压的应答断开连接", func() {
+			barsEventId := pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId
+			server.InjectFault(barsEventId, tdxtest.FaultBadCompression)
+
+			_, err := tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+			So(err, ShouldEqual, ErrDisconnected)
+			So(tdxClient.State(), ShouldEqual, StateClosed)
+		})
+
+		Convey("测试服务器断开连接", func() {
+			server.Disconnect()
+
+			var reason error
+			select {
+			case reason = <-tdxClient.Disconnected:
+			case <-time.After(time.Second):
+			}
+			So(reason, ShouldEqual, ErrDisconnected)
+
+			So(tdxClient.State(), ShouldEqual, StateClosed)
+			_, err := tdxClient.GetStockList(ctx, 0)
+			So(err, ShouldEqual, ErrNotConnected)
+		})
+
+		Convey("测试主动关闭连接时不发送断开通知", func() {
+			tdxClient.Close()
+			So(tdxClient.State(), ShouldEqual, StateClosed)
+			So(len(tdxClient.Disconnected), ShouldEqual, 0)
+		})
+	})
+	Convey("测试自动重连并继续未完成的请求", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.Reconnect = &ReconnectPolicy{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		// 服务器收到K线请求后断开连接, 重连后重新发送该请求
+		barsEventId := pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId
+		server.InjectFault(barsEventId, tdxtest.FaultDisconnect)
+
+		dayList, err := tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+		So(dayList, ShouldHaveLength, 4)
+		So(tdxClient.State(), ShouldEqual, StateReady)
+		So(len(tdxClient.Disconnected), ShouldEqual, 0)
+
+		// 重连时重新注册设备
+		deviceEventId := pkg.GenerateDeviceNode(0, 0).EventId
+		deviceCount := 0
+		for _, req := range server.Requests() {
+			if deviceEventId == req.EventId { deviceCount++ }
+		}
+		So(deviceCount, ShouldEqual, 2)
+
+		Convey("测试重连失败时发送断开通知", func() {
+			server.Close()
+
+			var reason error
+			select {
+			case reason = <-tdxClient.Disconnected:
+			case <-time.After(time.Second):
+			}
+			So(reason, ShouldNotBeNil)
+			So(tdxClient.State(), ShouldEqual, StateClosed)
+		})
+	})
+
+	Convey("测试重连等待时间", t, func() {
+		minBackoff, maxBackoff := (&ReconnectPolicy{}).backoffRange()
+		So(minBackoff, ShouldEqual, time.Second)
+		So(maxBackoff, ShouldEqual, time.Minute)
+
+		minBackoff, maxBackoff = (&ReconnectPolicy{MinBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond}).backoffRange()
+		So(minBackoff, ShouldEqual, 10*time.Millisecond)
+		So(maxBackoff, ShouldEqual, 10*time.Millisecond)
+
+		for idx := 0; idx < 100; idx++ {
+			wait := jitter(time.Second)
+			So(wait, ShouldBeGreaterThanOrEqualTo, 500*time.Millisecond)
+			So(wait, ShouldBeLessThanOrEqualTo, time.Second)
+		}
+	})
+
+	Convey("测试收发封包的拦截器", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		barsEventId := pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId
+		recorder := pkg.NewLatencyRecorder()
+
+		bonusEventId := pkg.GenerateStockBonus(nil, 0).EventId
+		rejectBonus := pkg.Interceptor{Send: func(reqNode *pkg.RequestNode) error {
+			if bonusEventId == reqNode.EventId { return pkg.ErrDropPacket }
+			return nil
+		}}
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.Interceptors = []pkg.Interceptor{recorder.Interceptor(), pkg.DropInterceptor(barsEventId, 1), rejectBonus}
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		// 第一个K线应答被丢弃
+		timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = tdxClient.GetDayBars(timeoutCtx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldEqual, ErrRequestTimeout)
+
+		dayList, err := tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+		So(dayList, ShouldHaveLength, 4)
+
+		So(recorder.Stats(), ShouldContainKey, pkg.GenerateStockDayItem(0, "", 0, 0, 0).CmdId)
+
+		// 被拦截器放弃的请求不影响连接
+		_, err = tdxClient.GetBonus(ctx, []string{"0000001"})
+		So(err, ShouldNotBeNil)
+		So(tdxClient.State(), ShouldEqual, StateReady)
+		So(tdxClient.dispatcher.Pending(), ShouldEqual, 0)
+	})
+
+	Convey("测试保存未知的应答封包", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		dumpDir, err := ioutil.TempDir("", "ctdx_unknown_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dumpDir)
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.UnknownPacketPath = dumpDir
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		// 公告不是未知封包
+		noticeNode := pkg.ResponseNode{ResponseHeader: pkg.ResponseHeader{EventId: 0x0FDB}, RawData: append(make([]byte, 0xB2), []byte(strings.Repeat("notice ", 20))...)}
+		tdxClient.dispatcher.HandleProc(nil, noticeNode)
+
+		unknownNode := pkg.ResponseNode{ResponseHeader: pkg.ResponseHeader{Index: 0x10, CmdId: 0x56, EventId: 0x1234},
+			RawData: []byte{0x01, 0x02}, CompressedBody: []byte{0x78, 0x9C}}
+		tdxClient.dispatcher.HandleProc(nil, unknownNode)
+
+		dumpList, err := filepath.Glob(filepath.Join(dumpDir, "*", "*", "*.json"))
+		So(err, ShouldBeNil)
+		So(dumpList, ShouldHaveLength, 1)
+		So(filepath.Dir(dumpList[0]), ShouldEqual, filepath.Join(dumpDir, "0x1234", "0x0056"))
+
+		dump, err := pkg.LoadPacketDump(dumpList[0])
+		So(err, ShouldBeNil)
+		So([]byte(dump.RawBody), ShouldResemble, []byte{0x78, 0x9C})
+	})
+
+	Convey("测试回放封包记录", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		captureDir, err := ioutil.TempDir("", "ctdx_replay_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(captureDir)
+
+		capturePath := filepath.Join(captureDir, "replay.cap")
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.CapturePath = capturePath
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+
+		_, err = tdxClient.GetStockList(ctx, 1)
+		So(err, ShouldBeNil)
+		_, err = tdxClient.GetBonus(ctx, []string{"0000001"})
+		So(err, ShouldBeNil)
+		_, err = tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+		tdxClient.Close()
+
+		result, err := newTestClient(server.Addr()).Replay(capturePath)
+		So(err, ShouldBeNil)
+
+		So(result.StockList, ShouldHaveLength, 2)
+		So(result.StockList[0].Code, ShouldEqual, "600000")
+		So(result.StockList[0].Market, ShouldEqual, 1)
+
+		So(result.Bonus, ShouldHaveLength, 1)
+		So(result.Bonus[0].Date, ShouldEqual, 20170713)
+
+		So(result.DayBars, ShouldHaveLength, 4)
+		So(result.DayBars[0].Market, ShouldEqual, 1)
+		So(result.DayBars[0].Code, ShouldEqual, "600000")
+		So(result.DayBars[0].Date, ShouldEqual, 20180102)
+	})
+
+	Convey("测试封包记录中保存拦截器处理后的请求及解压前的应答", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		captureDir, err := ioutil.TempDir("", "ctdx_capture_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(captureDir)
+
+		barsEventId := pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId
+		bonusEventId := pkg.GenerateStockBonus(nil, 0).EventId
+		rewrite := pkg.Interceptor{Send: func(reqNode *pkg.RequestNode) error {
+			switch reqNode.EventId {
+			case bonusEventId: return pkg.ErrDropPacket
+			case barsEventId: *reqNode = pkg.GenerateStockDayItem(1, "000001", 20180102, 20180105, reqNode.Index)
+			}
+			return nil
+		}}
+
+		capturePath := filepath.Join(captureDir, "intercept.cap")
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.CapturePath = capturePath
+		tdxClient.Interceptors = []pkg.Interceptor{rewrite}
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+
+		_, err = tdxClient.GetBonus(ctx, []string{"0000001"})
+		So(err, ShouldNotBeNil)
+		_, err = tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+		tdxClient.Close()
+
+		captureFile, err := os.Open(capturePath)
+		So(err, ShouldBeNil)
+		defer captureFile.Close()
+		recordList, err := pkg.ReadCapture(captureFile)
+		So(err, ShouldBeNil)
+
+		var barsCodes []string
+		compressedCount := 0
+		for _, record := range recordList {
+			if pkg.CaptureResponse == record.Direction {
+				// 压缩的应答同时记录解压前的包体
+				if 0 != record.Response.IsCompress & 0x10 {
+					So(record.Response.CompressedBody, ShouldNotBeEmpty)
+					compressedCount++
+				}
+				continue
+			}
+			So(record.Request.EventId, ShouldNotEqual, bonusEventId)
+			if barsEventId == record.Request.EventId {
+				_, _, code, err := pkg.ParseStockBarsItem(record.Request)
+				So(err, ShouldBeNil)
+				barsCodes = append(barsCodes, code)
+			}
+		}
+		So(barsCodes, ShouldResemble, []string{"000001"})
+		So(compressedCount, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("测试记录封包时关闭连接", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		captureDir, err := ioutil.TempDir("", "ctdx_capture_close_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(captureDir)
+
+		capturePath := filepath.Join(captureDir, "close.cap")
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.CapturePath = capturePath
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+
+		// 多个协程持续请求直到连接关闭, 使 Close 发生在收发及记录封包的过程中
+		var waitGroup sync.WaitGroup
+		var received int32
+		for idx := 0; idx < 4; idx++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				for {
+					if _, err := tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105); nil != err { return }
+					atomic.AddInt32(&received, 1)
+				}
+			}()
+		}
+
+		for deadline := time.Now().Add(time.Second); 8 > atomic.LoadInt32(&received) && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		tdxClient.Close()
+		waitGroup.Wait()
+		So(tdxClient.State(), ShouldEqual, StateClosed)
+
+		captureFile, err := os.Open(capturePath)
+		So(err, ShouldBeNil)
+		defer captureFile.Close()
+		recordList, err := pkg.ReadCapture(captureFile)
+		So(err, ShouldBeNil)
+		So(len(recordList), ShouldBeGreaterThan, 1)
+	})
+
+	Convey("测试无法解析的K线应答返回错误", t, func() {
+		tdxClient := newTestClient("")
+
+		// 包体声明了32字节的K线数据, 实际为空
+		badNode := pkg.ResponseNode{RawData: []byte{0x00, 0x00, 0x20, 0x00, 0x00, 0x00}}
+		So(tdxClient.onStockHistory(pkg.PeriodDay, 1, "600000", badNode), ShouldNotBeNil)
+		So(tdxClient.onStockHistory(pkg.PeriodMin5, 1, "600000", badNode), ShouldNotBeNil)
+		So(tdxClient.onStockHistory(pkg.PeriodDay, 0, "399001", badNode), ShouldNotBeNil)
+
+		// 区间内没有行情数据不是错误
+		emptyNode := pkg.ResponseNode{RawData: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}
+		So(tdxClient.onStockHistory(pkg.PeriodDay, 1, "600000", emptyNode), ShouldBeNil)
+	})
+
+	Convey("测试F10目录及文件元信息的解析", t, func() {
+		categoryList := pkg.CompanyInfoCategoryList{Items: []pkg.CompanyInfoCategoryItem{
+			{Name: "CompanyProfile", FileName: "600000.txt", Start: 0, Length: 100},
+			{Name: "Finance", FileName: "600000.txt", Start: 100, Length: 200}}}
+		rawData, err := pkg.Marshal(categoryList)
+		So(err, ShouldBeNil)
+
+		categories, err := decodeCompanyInfoCategory(rawData)
+		So(err, ShouldBeNil)
+		So(categories, ShouldResemble, []CompanyInfoCategoryModel{
+			{"CompanyProfile", "600000.txt", 0, 100}, {"Finance", "600000.txt", 100, 200}})
+
+		_, err = decodeCompanyInfoCategory(rawData[:len(rawData)-1])
+		So(err, ShouldNotBeNil)
+
+		rawData, err = pkg.Marshal(pkg.FileMetaItem{Size: 1024})
+		So(err, ShouldBeNil)
+		fileMeta, err := decodeFileMeta(rawData)
+		So(err, ShouldBeNil)
+		So(fileMeta.Size, ShouldEqual, 1024)
+
+		_, err = decodeFileMeta(rawData[:4])
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("测试K线所在周期的第一天", t, func() {
+		So(periodStartDate(pkg.PeriodDay, 20180105), ShouldEqual, 20180105)
+		So(periodStartDate(pkg.PeriodMin5, 20180105), ShouldEqual, 20180105)
+		So(periodStartDate(pkg.PeriodWeek, 20180105), ShouldEqual, 20180101)
+		So(periodStartDate(pkg.PeriodWeek, 20180107), ShouldEqual, 20180101)
+		So(periodStartDate(pkg.PeriodWeek, 20180101), ShouldEqual, 20180101)
+		So(periodStartDate(pkg.PeriodWeek, 20170102), ShouldEqual, 20170102)
+		So(periodStartDate(pkg.PeriodWeek, 20170101), ShouldEqual, 20161226)
+		So(periodStartDate(pkg.PeriodMonth, 20180228), ShouldEqual, 20180201)
+		So(periodStartDate(pkg.PeriodQuarter, 20180515), ShouldEqual, 20180401)
+		So(periodStartDate(pkg.PeriodQuarter, 20181231), ShouldEqual, 20181001)
+		So(periodStartDate(pkg.PeriodYear, 20180515), ShouldEqual, 20180101)
+	})
+
+	Convey("测试空闲时发送心跳请求", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.HeartbeatInterval = 20 * time.Millisecond
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		countEventId := pkg.GenerateMarketStockCount(0).EventId
+		handshakeCount := 0
+		for _, req := range server.Requests() {
+			if countEventId == req.EventId { handshakeCount++ }
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		heartbeatCount := -handshakeCount
+		for _, req := range server.Requests() {
+			if countEventId == req.EventId { heartbeatCount++ }
+		}
+		So(heartbeatCount, ShouldBeGreaterThan, 0)
+		So(tdxClient.State(), ShouldEqual, StateReady)
+	})
+}
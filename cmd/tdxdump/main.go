@@ -0,0 +1,147 @@
+/**
+ * tdxdump: 查看 TdxClient.UnknownPacketPath 中保存的应答封包
+ *
+ *   tdxdump [-struct 结构名] 封包文件或目录...
+ *
+ * 输出包头、解压前后的包体, 并按已知的封包结构逐个字段标出偏移、原始字节及解码后的值,
+ * 未指定 -struct 时按 EventId 选择已知的结构
+ */
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"sort"
+	"strings"
+	"encoding/hex"
+	"path/filepath"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+// 可用于对照的已知封包结构
+var knownStructs = map[string]func() interface{}{
+	"MarketInitInfo": func() interface{} { return new(pkg.MarketInitInfo) },
+	"StockBaseList":  func() interface{} { return new(pkg.StockBaseList) },
+	"StockBonusList": func() interface{} { return new(pkg.StockBonusList) },
+	"StockDayList":   func() interface{} { return new(pkg.StockDayList) },
+	"IndexDayList":   func() interface{} { return new(pkg.IndexDayList) },
+	"StockMinsList":  func() interface{} { return new(pkg.StockMinsList) },
+	"FileMetaItem":   func() interface{} { return new(pkg.FileMetaItem) },
+	"CompanyInfoCategoryList": func() interface{} { return new(pkg.CompanyInfoCategoryList) },
+}
+
+/**
+ * 按 EventId 选择默认的结构
+ */
+func defaultStruct(eventId uint16) string {
+	eventStructs := map[uint16]string{
+		pkg.GenerateMarketInitInfo().EventId:              "MarketInitInfo",
+		pkg.GenerateMarketStockBase(0, 0).EventId:         "StockBaseList",
+		pkg.GenerateStockBonus(nil, 0).EventId:            "StockBonusList",
+		pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId:  "StockDayList",
+		pkg.GenerateFileMeta("").EventId:                  "FileMetaItem",
+		pkg.GenerateCompanyInfoCategory(0, "").EventId:    "CompanyInfoCategoryList",
+	}
+	return eventStructs[eventId]
+}
+
+func structNames() string {
+	var names []string
+	for name := range knownStructs { names = append(names, name) }
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+/**
+ * 输出字段的对照表, 解码失败时输出已解码的字段及剩余的字节
+ */
+func printOverlay(body []byte, structName string) {
+	newStruct, exists := knownStructs[structName]
+	if !exists {
+		fmt.Printf("未知的结构: %s, 可用的结构: %s\n", structName, structNames())
+		return
+	}
+
+	overlay, err := pkg.Overlay(body, newStruct())
+	fmt.Printf("按 %s 解析:\n", structName)
+	// 中文字符占两列, 表头按显示宽度对齐
+	fmt.Printf("  偏移%5s长度%3s字段%29s原始字节%17s值\n", "", "", "", "")
+
+	end := 0
+	for _, field := range overlay {
+		raw := hex.EncodeToString(body[field.Offset:field.Offset+field.Length])
+		if 24 < len(raw) { raw = raw[:21] + "..." }
+
+		value := fmt.Sprintf("%v", field.Value)
+		if text, ok := field.Value.(string); ok { value = fmt.Sprintf("%q", text) }
+
+		fmt.Printf("  0x%04X   %-6d %-32s %-24s %s\n", field.Offset, field.Length, field.Name, raw, value)
+		if field.Offset+field.Length > end { end = field.Offset+field.Length }
+	}
+
+	if nil != err { fmt.Printf("  解析失败: %v\n", err) }
+	if end < len(body) {
+		fmt.Printf("  剩余 %d 字节未解析:\n%s", len(body)-end, hex.Dump(body[end:]))
+	}
+}
+
+func dumpFile(path string, structName string) error {
+	dump, err := pkg.LoadPacketDump(path)
+	if nil != err { return err }
+
+	header := dump.Header
+	fmt.Printf("==== %s\n", path)
+	fmt.Printf("时间: %s\n", dump.Time.Format("2006-01-02 15:04:05.000"))
+	fmt.Printf("包头: flag:0x%08X, compress:0x%02X, index:0x%04X, cmd:0x%04X, unknown1:0x%02X, event:0x%04X, "+
+		"length:%d, max_length:%d\n", header.PacketFlag, header.IsCompress, header.Index, header.CmdId,
+		header.Unknown1, header.EventId, header.BodyLength, header.BodyMaxLength)
+
+	if 0 != header.IsCompress & 0x10 {
+		fmt.Printf("解压前的包体(%d 字节):\n%s", len(dump.RawBody), hex.Dump(dump.RawBody))
+	}
+	fmt.Printf("包体(%d 字节):\n%s", len(dump.Body), hex.Dump(dump.Body))
+
+	if 0 == len(structName) { structName = defaultStruct(header.EventId) }
+	if 0 < len(structName) { printOverlay(dump.Body, structName) }
+
+	fmt.Println()
+	return nil
+}
+
+func main() {
+	structName := flag.String("struct", "", "按指定的结构解析包体, 可用的结构: "+structNames())
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: %s [-struct 结构名] 封包文件或目录...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if 0 == flag.NArg() {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, root := range flag.Args() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if nil != err { return err }
+			// 目录中只处理 .json 文件, 直接指定的文件不限制
+			if info.IsDir() || (root != path && ".json" != filepath.Ext(path)) { return nil }
+
+			if err = dumpFile(path, *structName); nil != err {
+				fmt.Fprintf(os.Stderr, "读取 %s 失败: %v\n", path, err)
+				failed = true
+			}
+			return nil
+		})
+
+		if nil != err {
+			fmt.Fprintf(os.Stderr, "读取 %s 失败: %v\n", root, err)
+			failed = true
+		}
+	}
+
+	if failed { os.Exit(1) }
+}
@@ -0,0 +1,183 @@
+package comm
+
+import (
+	"fmt"
+	"sync"
+	"strings"
+	"io/ioutil"
+	"encoding/binary"
+
+	"github.com/datochan/gcom/utils"
+)
+
+// 单例避免重复IO操作, 板块文件更新后由 ResetStockBlocks 清除
+var (
+	gBlocksLock sync.Mutex
+	gBlocks     *StockBlocks
+)
+
+const (
+	blockHeaderSize = 384   // 板块文件的文件头长度
+	blockNameSize   = 9     // 板块名称长度
+	blockCodeSize   = 7     // 成分股代码长度
+	blockItemSize   = 2800  // 每个板块成分股区域的固定长度
+)
+
+// 板块文件名与板块分类的对应关系
+var BlockFiles = map[string]string{
+	"block_zs.dat": "zs",      // 指数板块
+	"block_fg.dat": "fg",      // 风格板块
+	"block_gn.dat": "gn",      // 概念板块
+	"block.dat":    "default", // 一般板块
+}
+
+// 板块信息
+type BlockModel struct {
+	Name      string    // 板块名称
+	Type      int       // 板块类型
+	Category  string    // 板块分类: zs, fg, gn, default
+	Codes     []string  // 成分股代码
+}
+
+type StockBlocks struct {
+	blockList   []BlockModel
+	codeIndex   map[string][]int   // 股票代码 => 所属板块在blockList中的位置
+}
+
+/**
+ * 解析通达信的板块文件
+ */
+func ParseBlockFile(content []byte, category string) ([]BlockModel, error) {
+	var blockList []BlockModel
+
+	if len(content) < blockHeaderSize+2 {
+		return nil, fmt.Errorf("板块文件长度不足")
+	}
+
+	pos := blockHeaderSize
+	blockCount := int(binary.LittleEndian.Uint16(content[pos:pos+2]))
+	pos += 2
+
+	for idx := 0; idx < blockCount; idx++ {
+		if len(content) < pos+blockNameSize+4 {
+			return nil, fmt.Errorf("板块文件在第 %d 个板块处被截断", idx+1)
+		}
+
+		var block BlockModel
+		block.Category = category
+		block.Name = utils.ConvertTo(strings.TrimRight(string(content[pos:pos+blockNameSize]), "\x00"), "gbk", "utf8")
+		pos += blockNameSize
+
+		stockCount := int(binary.LittleEndian.Uint16(content[pos:pos+2]))
+		block.Type = int(binary.LittleEndian.Uint16(content[pos+2:pos+4]))
+		pos += 4
+
+		if len(content) < pos+stockCount*blockCodeSize {
+			return nil, fmt.Errorf("板块 %s 的成分股被截断", block.Name)
+		}
+
+		for codeIdx := 0; codeIdx < stockCount; codeIdx++ {
+			start := pos + codeIdx*blockCodeSize
+			block.Codes = append(block.Codes, strings.TrimRight(string(content[start:start+blockCodeSize]), "\x00"))
+		}
+
+		pos += blockItemSize
+		blockList = append(blockList, block)
+	}
+
+	return blockList, nil
+}
+
+/**
+ * 载入板块数据, 目录中不存在的板块文件会被忽略
+ */
+func DefaultStockBlocks(conf IConfigure) (*StockBlocks, error) {
+	gBlocksLock.Lock()
+	defer gBlocksLock.Unlock()
+
+	if nil != gBlocks {
+		return gBlocks, nil
+	}
+
+	blocks := &StockBlocks{codeIndex: make(map[string][]int)}
+	blockDir := fmt.Sprintf("%s%s", conf.GetApp().DataPath, conf.GetTdx().Files.StockBlock)
+
+	for fileName, category := range BlockFiles {
+		filePath := fmt.Sprintf("%s%s", blockDir, fileName)
+		exist, _ := utils.FileExists(filePath)
+		if !exist { continue }
+
+		content, err := ioutil.ReadFile(filePath)
+		if nil != err {
+			return nil, fmt.Errorf("读取板块文件 %s 出错, Err=%v", fileName, err)
+		}
+
+		blockList, err := ParseBlockFile(content, category)
+		if nil != err {
+			return nil, fmt.Errorf("解析板块文件 %s 出错, Err=%v", fileName, err)
+		}
+
+		blocks.Append(blockList...)
+	}
+
+	if 0 >= len(blocks.blockList) {
+		return nil, fmt.Errorf("目录 %s 中没有板块文件", blockDir)
+	}
+
+	gBlocks = blocks
+	return gBlocks, nil
+}
+
+/**
+ * 清除缓存的板块数据, 下次调用 DefaultStockBlocks 时重新载入板块文件
+ */
+func ResetStockBlocks() {
+	gBlocksLock.Lock()
+	gBlocks = nil
+	gBlocksLock.Unlock()
+}
+
+/**
+ * 添加板块信息
+ */
+func (sb *StockBlocks) Append(blocks ...BlockModel) {
+	if nil == sb.codeIndex { sb.codeIndex = make(map[string][]int) }
+
+	for _, block := range blocks {
+		sb.blockList = append(sb.blockList, block)
+		for _, code := range block.Codes {
+			sb.codeIndex[code] = append(sb.codeIndex[code], len(sb.blockList)-1)
+		}
+	}
+}
+
+/**
+ * 所有的板块信息
+ */
+func (sb *StockBlocks) Blocks() []BlockModel {
+	return sb.blockList
+}
+
+/**
+ * 指定股票所属的所有板块
+ */
+func (sb *StockBlocks) BlocksOf(code string) []BlockModel {
+	var blockList []BlockModel
+	for _, idx := range sb.codeIndex[code] {
+		blockList = append(blockList, sb.blockList[idx])
+	}
+	return blockList
+}
+
+/**
+ * 指定板块的成分股, 同名板块的成分股会被合并
+ */
+func (sb *StockBlocks) MembersOf(name string) []string {
+	var codeList []string
+	for _, block := range sb.blockList {
+		if 0 == strings.Compare(block.Name, name) {
+			codeList = append(codeList, block.Codes...)
+		}
+	}
+	return codeList
+}
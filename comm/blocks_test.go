@@ -0,0 +1,95 @@
+package comm
+
+import (
+	"os"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func makeBlockFile(blocks []BlockModel) []byte {
+	var buffer bytes.Buffer
+	buffer.Write(make([]byte, blockHeaderSize))
+	binary.Write(&buffer, binary.LittleEndian, uint16(len(blocks)))
+
+	for _, block := range blocks {
+		name := make([]byte, blockNameSize)
+		copy(name, block.Name)
+		buffer.Write(name)
+		binary.Write(&buffer, binary.LittleEndian, uint16(len(block.Codes)))
+		binary.Write(&buffer, binary.LittleEndian, uint16(block.Type))
+
+		codes := make([]byte, blockItemSize)
+		for idx, code := range block.Codes {
+			copy(codes[idx*blockCodeSize:], code)
+		}
+		buffer.Write(codes)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestParseBlockFile(t *testing.T) {
+	content := makeBlockFile([]BlockModel{
+		{Name: "HS300", Type: 2, Codes: []string{"600000", "000001"}},
+		{Name: "SZ50", Type: 2, Codes: []string{"600000"}},
+	})
+
+	Convey("测试板块文件的解析", t, func() {
+		blockList, err := ParseBlockFile(content, "zs")
+		So(err, ShouldBeNil)
+		So(blockList, ShouldHaveLength, 2)
+		So(blockList[0].Name, ShouldEqual, "HS300")
+		So(blockList[0].Type, ShouldEqual, 2)
+		So(blockList[0].Category, ShouldEqual, "zs")
+		So(blockList[0].Codes, ShouldResemble, []string{"600000", "000001"})
+
+		Convey("测试板块的查询", func() {
+			blocks := new(StockBlocks)
+			blocks.Append(blockList...)
+
+			So(blocks.BlocksOf("600000"), ShouldHaveLength, 2)
+			So(blocks.BlocksOf("000001")[0].Name, ShouldEqual, "HS300")
+			So(blocks.BlocksOf("000002"), ShouldBeEmpty)
+			So(blocks.MembersOf("SZ50"), ShouldResemble, []string{"600000"})
+		})
+	})
+
+	Convey("测试板块文件更新后重新载入", t, func() {
+		dataPath, err := ioutil.TempDir("", "ctdx_blocks_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataPath)
+
+		conf := new(Conf)
+		conf.App.DataPath = dataPath + "/"
+		blockPath := filepath.Join(dataPath, "block_zs.dat")
+
+		ResetStockBlocks()
+		defer ResetStockBlocks()
+
+		So(ioutil.WriteFile(blockPath, content, 0666), ShouldBeNil)
+		blocks, err := DefaultStockBlocks(conf)
+		So(err, ShouldBeNil)
+		So(blocks.Blocks(), ShouldHaveLength, 2)
+
+		newContent := makeBlockFile([]BlockModel{{Name: "HS300", Type: 2, Codes: []string{"600000"}}})
+		So(ioutil.WriteFile(blockPath, newContent, 0666), ShouldBeNil)
+		blocks, err = DefaultStockBlocks(conf)
+		So(err, ShouldBeNil)
+		So(blocks.Blocks(), ShouldHaveLength, 2)
+
+		ResetStockBlocks()
+		blocks, err = DefaultStockBlocks(conf)
+		So(err, ShouldBeNil)
+		So(blocks.Blocks(), ShouldHaveLength, 1)
+		So(blocks.BlocksOf("000001"), ShouldBeEmpty)
+	})
+
+	Convey("测试被截断的板块文件", t, func() {
+		_, err := ParseBlockFile(content[:blockHeaderSize+10], "zs")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -27,10 +27,22 @@ type CTdx struct {
 		StockBonus string `toml:"stock_bonus"`
 		StockDay string `toml:"stock_day"`
 		StockMin string `toml:"stock_min"`
+		StockMin1 string `toml:"stock_min1"`
+		StockMin15 string `toml:"stock_min15"`
+		StockMin30 string `toml:"stock_min30"`
+		StockMin60 string `toml:"stock_min60"`
+		StockWeek string `toml:"stock_week"`
+		StockMonth string `toml:"stock_month"`
+		StockQuarter string `toml:"stock_quarter"`
+		StockYear string `toml:"stock_year"`
 		StockReport string `toml:"stock_report"`
+		StockBlock string `toml:"stock_block"`
+		IndustryList string `toml:"industry_list"`
+		IndustryIndex string `toml:"industry_index"`
 	} `toml:"files"`
 	Server struct {
 		DataHost string `toml:"data_host"`
+		DataHosts []string `toml:"data_hosts"`
 		MonitorHost string `toml:"monitor_host"`
 	} `toml:"server"`
 }
@@ -0,0 +1,158 @@
+package comm
+
+import (
+	"fmt"
+	"sync"
+	"strings"
+	"strconv"
+	"io/ioutil"
+
+	"github.com/datochan/gcom/utils"
+)
+
+// 单例避免重复IO操作, 行业文件更新后由 ResetStockIndustry 清除
+var (
+	gIndustryLock sync.Mutex
+	gIndustry     *StockIndustry
+)
+
+// 股票所属行业(tdxhy.cfg)
+type IndustryModel struct {
+	Market       int     // 所属市场，0深交所，1上交所
+	Code         string  // 股票代码
+	TdxCode      string  // 通达信行业代码, 如: T100101
+	SwCode       string  // 申万行业代码, 如: X500102
+}
+
+// 行业指数定义(tdxzs.cfg)
+type IndustryIndexModel struct {
+	Name         string  // 行业名称
+	Code         string  // 行业指数代码, 如: 880301
+	Type         int     // 指数类型
+	IndustryCode string  // 对应的行业代码, 如: T0101
+}
+
+type StockIndustry struct {
+	industryMap  map[string]IndustryModel       // 市场标识+股票代码 => 所属行业
+	indexMap     map[string]IndustryIndexModel  // 行业代码 => 行业指数
+}
+
+/**
+ * 解析股票所属行业的文件(tdxhy.cfg)
+ * 格式: 市场|股票代码|通达信行业代码|||申万行业代码
+ */
+func ParseIndustryFile(content []byte) []IndustryModel {
+	var industryList []IndustryModel
+
+	for _, line := range strings.Split(utils.ConvertTo(string(content), "gbk", "utf8"), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) < 3 { continue }
+
+		market, err := strconv.Atoi(fields[0])
+		if nil != err { continue }
+
+		industry := IndustryModel{Market: market, Code: fields[1], TdxCode: fields[2]}
+		if len(fields) > 5 { industry.SwCode = fields[5] }
+
+		industryList = append(industryList, industry)
+	}
+
+	return industryList
+}
+
+/**
+ * 解析行业指数定义的文件(tdxzs.cfg)
+ * 格式: 行业名称|指数代码|指数类型|||行业代码
+ */
+func ParseIndustryIndexFile(content []byte) []IndustryIndexModel {
+	var indexList []IndustryIndexModel
+
+	for _, line := range strings.Split(utils.ConvertTo(string(content), "gbk", "utf8"), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) < 6 || 0 >= len(fields[5]) { continue }
+
+		indexType, _ := strconv.Atoi(fields[2])
+		indexList = append(indexList, IndustryIndexModel{fields[0], fields[1], indexType, fields[5]})
+	}
+
+	return indexList
+}
+
+/**
+ * 由解析结果构造行业信息
+ */
+func NewStockIndustry(industryList []IndustryModel, indexList []IndustryIndexModel) *StockIndustry {
+	industry := &StockIndustry{make(map[string]IndustryModel), make(map[string]IndustryIndexModel)}
+
+	for _, item := range industryList {
+		industry.industryMap[fmt.Sprintf("%d%s", item.Market, item.Code)] = item
+	}
+
+	for _, item := range indexList {
+		industry.indexMap[item.IndustryCode] = item
+	}
+
+	return industry
+}
+
+/**
+ * 载入行业数据
+ */
+func DefaultStockIndustry(conf IConfigure) (*StockIndustry, error) {
+	gIndustryLock.Lock()
+	defer gIndustryLock.Unlock()
+
+	if nil != gIndustry {
+		return gIndustry, nil
+	}
+
+	industryPath := fmt.Sprintf("%s%s", conf.GetApp().DataPath, conf.GetTdx().Files.IndustryList)
+	content, err := ioutil.ReadFile(industryPath)
+	if nil != err {
+		return nil, fmt.Errorf("读取行业文件失败, Err=%v", err)
+	}
+	industryList := ParseIndustryFile(content)
+
+	indexPath := fmt.Sprintf("%s%s", conf.GetApp().DataPath, conf.GetTdx().Files.IndustryIndex)
+	content, err = ioutil.ReadFile(indexPath)
+	if nil != err {
+		return nil, fmt.Errorf("读取行业指数文件失败, Err=%v", err)
+	}
+	indexList := ParseIndustryIndexFile(content)
+
+	gIndustry = NewStockIndustry(industryList, indexList)
+	return gIndustry, nil
+}
+
+/**
+ * 清除缓存的行业数据, 下次调用 DefaultStockIndustry 时重新载入行业文件
+ */
+func ResetStockIndustry() {
+	gIndustryLock.Lock()
+	gIndustry = nil
+	gIndustryLock.Unlock()
+}
+
+/**
+ * 指定股票所属的行业
+ */
+func (si *StockIndustry) IndustryOf(market int, code string) (IndustryModel, bool) {
+	industry, ok := si.industryMap[fmt.Sprintf("%d%s", market, code)]
+	return industry, ok
+}
+
+/**
+ * 指定股票所属的通达信行业指数, 按行业代码由细到粗匹配
+ */
+func (si *StockIndustry) IndustryIndexOf(market int, code string) (IndustryIndexModel, bool) {
+	industry, ok := si.IndustryOf(market, code)
+	if !ok { return IndustryIndexModel{}, false }
+
+	for tdxCode := industry.TdxCode; len(tdxCode) > 1; tdxCode = tdxCode[:len(tdxCode)-2] {
+		if index, ok := si.indexMap[tdxCode]; ok {
+			return index, true
+		}
+	}
+
+	return IndustryIndexModel{}, false
+}
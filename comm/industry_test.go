@@ -0,0 +1,112 @@
+package comm
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/kniren/gota/series"
+	"github.com/kniren/gota/dataframe"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+/**
+ * 在临时目录中写入行业文件, 返回对应的配置
+ */
+func makeIndustryConf(dataPath string, industryContent, indexContent []byte) (*Conf, error) {
+	conf := new(Conf)
+	conf.App.DataPath = dataPath + "/"
+	conf.Tdx.Files.IndustryList = "tdxhy.cfg"
+	conf.Tdx.Files.IndustryIndex = "tdxzs.cfg"
+
+	err := ioutil.WriteFile(filepath.Join(dataPath, conf.Tdx.Files.IndustryList), industryContent, 0666)
+	if nil != err { return nil, err }
+
+	return conf, ioutil.WriteFile(filepath.Join(dataPath, conf.Tdx.Files.IndustryIndex), indexContent, 0666)
+}
+
+func TestStockIndustry(t *testing.T) {
+	industryContent := []byte("0|000001|T1001|||X480101\n1|600000|T100101|||X480101\n0|300999|T9999||\n")
+	indexContent := []byte("Bank|880471|2|1|0|T1001\nBank2|880472|2|1|0|T100102\nSH Index|999999|1|0|0|\n")
+
+	Convey("测试行业文件的解析", t, func() {
+		industryList := ParseIndustryFile(industryContent)
+		So(industryList, ShouldHaveLength, 3)
+		So(industryList[1].Market, ShouldEqual, 1)
+		So(industryList[1].TdxCode, ShouldEqual, "T100101")
+		So(industryList[1].SwCode, ShouldEqual, "X480101")
+
+		indexList := ParseIndustryIndexFile(indexContent)
+		So(indexList, ShouldHaveLength, 2)
+		So(indexList[0].Code, ShouldEqual, "880471")
+
+		Convey("测试股票与行业指数的匹配", func() {
+			industry := NewStockIndustry(industryList, indexList)
+
+			index, ok := industry.IndustryIndexOf(1, "600000")
+			So(ok, ShouldBeTrue)
+			So(index.Name, ShouldEqual, "Bank")
+
+			_, ok = industry.IndustryIndexOf(0, "300999")
+			So(ok, ShouldBeFalse)
+
+			_, ok = industry.IndustryOf(0, "000002")
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("测试结果中附加所属行业", t, func() {
+		dataPath, err := ioutil.TempDir("", "ctdx_industry_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataPath)
+
+		conf, err := makeIndustryConf(dataPath, industryContent, indexContent)
+		So(err, ShouldBeNil)
+
+		ResetStockIndustry()
+		defer ResetStockIndustry()
+
+		df := dataframe.New(
+			series.New([]string{"600000", "000002"}, series.String, "code"),
+			series.New([]int{1, 0}, series.Int, "market"))
+
+		resultDF := withIndustry(conf, df)
+		So(resultDF.Err, ShouldBeNil)
+		So(resultDF.Col("industry").Records(), ShouldResemble, []string{"Bank", ""})
+		So(resultDF.Col("industry_code").Records(), ShouldResemble, []string{"T100101", ""})
+		So(resultDF.Col("sw_industry_code").Records(), ShouldResemble, []string{"X480101", ""})
+	})
+
+	Convey("测试行业文件更新后重新载入", t, func() {
+		dataPath, err := ioutil.TempDir("", "ctdx_industry_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataPath)
+
+		conf, err := makeIndustryConf(dataPath, industryContent, indexContent)
+		So(err, ShouldBeNil)
+
+		ResetStockIndustry()
+		defer ResetStockIndustry()
+
+		industry, err := DefaultStockIndustry(conf)
+		So(err, ShouldBeNil)
+		_, ok := industry.IndustryOf(0, "000002")
+		So(ok, ShouldBeFalse)
+
+		newContent := append(industryContent, []byte("0|000002|T1001|||X480101\n")...)
+		_, err = makeIndustryConf(dataPath, newContent, indexContent)
+		So(err, ShouldBeNil)
+
+		industry, err = DefaultStockIndustry(conf)
+		So(err, ShouldBeNil)
+		_, ok = industry.IndustryOf(0, "000002")
+		So(ok, ShouldBeFalse)
+
+		ResetStockIndustry()
+		industry, err = DefaultStockIndustry(conf)
+		So(err, ShouldBeNil)
+		_, ok = industry.IndustryOf(0, "000002")
+		So(ok, ShouldBeTrue)
+	})
+}
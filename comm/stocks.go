@@ -162,6 +162,20 @@ const (
     INDUSTRY         // 行业指数 ...
 )
 
+// GetFinanceDataFrame 的附加选项, 不参与筛选, 取值与上面的证券类型区分开
+const (
+	WITHINDUSTRY = 0x100  // 结果中附加所属行业(industry, industry_code, sw_industry_code)列
+)
+
+/**
+ * 是否是指数(深399xxx, 沪000xxx)
+ */
+func IsIndex(market int, code string) bool {
+	if 0 == market { return strings.HasPrefix(code, "399") }
+	if 1 == market { return strings.HasPrefix(code, "000") }
+	return false
+}
+
 /**
  * 获取股票、基金、指数、行业等信息
  */
@@ -222,13 +236,7 @@ func GetFinanceDataFrame(conf IConfigure, types ...int) dataframe.DataFrame{
 
 		// 指数
 		if 0 <= utils.FindInIntegerSlice(INDEX, types) {
-			if 0 == item["market"] {
-				if 0 == strings.Index(item["code"].(string), "399") { recordIdx = append(recordIdx, idx) }
-			}
-
-			if 1 == item["market"] {
-				if 0 == strings.Index(item["code"].(string), "000") { recordIdx = append(recordIdx, idx) }
-			}
+			if IsIndex(item["market"].(int), item["code"].(string)) { recordIdx = append(recordIdx, idx) }
 		}
 
 		// 债券(todo: 债券代码待完善和补全, 不建议使用)
@@ -248,6 +256,34 @@ func GetFinanceDataFrame(conf IConfigure, types ...int) dataframe.DataFrame{
 			}
 		}
 	}
-	return baseDF.Subset(recordIdx)
+	resultDF := baseDF.Subset(recordIdx)
+	if 0 > utils.FindInIntegerSlice(WITHINDUSTRY, types) || nil != resultDF.Err { return resultDF }
+
+	return withIndustry(conf, resultDF)
+}
+
+/**
+ * 附加所属行业的信息, 没有行业信息的股票对应列为空
+ */
+func withIndustry(conf IConfigure, df dataframe.DataFrame) dataframe.DataFrame {
+	industry, err := DefaultStockIndustry(conf)
+	if nil != err { return dataframe.DataFrame{Err: err} }
+
+	var names, tdxCodes, swCodes []string
+	for _, item := range df.Maps() {
+		market := item["market"].(int)
+		code := item["code"].(string)
+
+		stockIndustry, _ := industry.IndustryOf(market, code)
+		industryIndex, _ := industry.IndustryIndexOf(market, code)
+
+		names = append(names, industryIndex.Name)
+		tdxCodes = append(tdxCodes, stockIndustry.TdxCode)
+		swCodes = append(swCodes, stockIndustry.SwCode)
+	}
+
+	return df.Mutate(series.New(names, series.String, "industry")).
+		Mutate(series.New(tdxCodes, series.String, "industry_code")).
+		Mutate(series.New(swCodes, series.String, "sw_industry_code"))
 }
 
@@ -1,5 +1,11 @@
 package comm
 
+import (
+	"math"
+	"errors"
+	"encoding/binary"
+)
+
 /**
  * 从tdx的封包中解密价格数据
  */
@@ -21,49 +27,150 @@ func BufferToDouble(buffer []byte) (int, float64) {
 	return idx+1, doubleValue
 }
 
-// todo: 待处理
-func DoubleToBuf(inputValue float64, lpTargetBuffer interface{}) {
-	//signed __int16 flag; // bx@1 数值的正负标记，负数为1，正数为0
-	//int idx; // esi@1
-	//signed __int64 deltaValue; // qax@2
-	//unsigned int v5; // eax@4
-	//BYTE v6; // cl@4
-	//int bufferLength; // eax@7
-	//LPVOID v8; // ecx@8
-	//BYTE v9; // dl@9
-	//
-	//idx = 0;
-	//flag = 0;
-	//if ( inputValue >= 0.0 ) {
-	//	deltaValue = (signed __int64)(inputValue + 0.5);
-	//} else {
-	//	deltaValue = (signed __int64)(0.5 - inputValue);
-	//	flag = 1;
-	//}
-	//v6 = deltaValue & 0x3F;
-	//v5 = (_DWORD)deltaValue >> 6;
-	//*(_BYTE *)lpTargetBuffer = v6;
-	//if ( flag )
-	//*(_BYTE *)lpTargetBuffer = v6 | 0x40;
-	//
-	//if ( v5 ) {
-	//	v8 = lpTargetBuffer;
-	//
-	//	do {
-	//		++idx;
-	//		*(_BYTE *)v8 |= 0x80u;
-	//		v8 = (char *)lpTargetBuffer + (signed __int16)idx;
-	//		v9 = v5 & 0x7F;
-	//		v5 >>= 7;
-	//		*(_BYTE *)v8 = v9;
-	//	} while ( v5 );
-	//
-	//	bufferLength = idx + 1;
-	//
-	//} else {
-	//	LOWORD(bufferLength) = 1;
-	//
-	//}
-	//
-	//return bufferLength;
+/**
+ * 解析tdx封包中以4字节表示的成交量/成交额
+ */
+func IntToVolume(intValue uint32) float64 {
+	logPoint := int(intValue >> 24)
+	hleax := intValue >> 16 & 0xFF
+	lheax := intValue >> 8 & 0xFF
+	lleax := intValue & 0xFF
+
+	dwEcx := logPoint*2 - 0x7F
+	dwEdx := logPoint*2 - 0x86
+	dwEsi := logPoint*2 - 0x8E
+	dwEax := logPoint*2 - 0x96
+
+	dblXmm6 := math.Pow(2.0, math.Abs(float64(dwEcx)))
+	if dwEcx < 0 { dblXmm6 = 1.0 / dblXmm6 }
+
+	var dblXmm4 float64
+	if hleax > 0x80 {
+		dblXmm4 = math.Pow(2.0, float64(dwEdx))*128.0 + float64(hleax & 0x7F)*math.Pow(2.0, float64(dwEdx+1))
+	} else if dwEdx >= 0 {
+		dblXmm4 = math.Pow(2.0, float64(dwEdx)) * float64(hleax)
+	} else {
+		// 与通达信的实现保持一致
+		dblXmm4 = 1.0 / math.Pow(2.0, float64(dwEdx)) * float64(hleax)
+	}
+
+	dblXmm3 := math.Pow(2.0, float64(dwEsi)) * float64(lheax)
+	dblXmm1 := math.Pow(2.0, float64(dwEax)) * float64(lleax)
+	if 0 != hleax & 0x80 {
+		dblXmm3 *= 2.0
+		dblXmm1 *= 2.0
+	}
+
+	return dblXmm6 + dblXmm4 + dblXmm3 + dblXmm1
+}
+
+/**
+ * 将价格数据加密成tdx封包中的格式, 与 BufferToDouble 互逆
+ */
+func DoubleToBuf(inputValue float64) []byte {
+	var flag byte
+	var deltaValue uint64
+
+	if inputValue >= 0.0 {
+		deltaValue = uint64(inputValue + 0.5)
+	} else {
+		deltaValue = uint64(0.5 - inputValue)
+		flag = 0x40 // 负数标记
+	}
+
+	buffer := []byte{byte(deltaValue & 0x3F) | flag}
+	for deltaValue >>= 6; 0 != deltaValue; deltaValue >>= 7 {
+		buffer[len(buffer)-1] |= 0x80
+		buffer = append(buffer, byte(deltaValue & 0x7F))
+	}
+
+	return buffer
+}
+
+// 价格数据被截断
+var ErrPriceTruncated = errors.New("价格数据被截断")
+
+/**
+ * 顺序解析封包中连续存放的价格数据, 数据不完整时返回错误而不是panic
+ */
+type PriceDecoder struct {
+	buffer []byte
+	pos    int
+}
+
+func NewPriceDecoder(buffer []byte) *PriceDecoder {
+	return &PriceDecoder{buffer: buffer}
+}
+
+/**
+ * 当前解析到的位置
+ */
+func (d *PriceDecoder) Pos() int {
+	return d.pos
+}
+
+/**
+ * 剩余未解析的数据长度
+ */
+func (d *PriceDecoder) Remaining() int {
+	return len(d.buffer) - d.pos
+}
+
+/**
+ * 解析下一个价格数据
+ */
+func (d *PriceDecoder) Next() (float64, error) {
+	end := d.pos
+	for end < len(d.buffer) && 0 != d.buffer[end] & 0x80 { end++ }
+	if end >= len(d.buffer) { return 0, ErrPriceTruncated }
+
+	length, value := BufferToDouble(d.buffer[d.pos:end+1])
+	d.pos += length
+
+	return value, nil
+}
+
+/**
+ * 解析下一个价格数据并转换为整数
+ */
+func (d *PriceDecoder) NextInt() (int, error) {
+	value, err := d.Next()
+	return int(value), err
+}
+
+/**
+ * 跳过n个字节的定长数据
+ */
+func (d *PriceDecoder) Skip(n int) error {
+	_, err := d.ReadBytes(n)
+	return err
+}
+
+/**
+ * 读取n个字节的定长数据
+ */
+func (d *PriceDecoder) ReadBytes(n int) ([]byte, error) {
+	if n < 0 || d.Remaining() < n { return nil, ErrPriceTruncated }
+
+	result := d.buffer[d.pos:d.pos+n]
+	d.pos += n
+	return result, nil
+}
+
+/**
+ * 读取小端序的uint16
+ */
+func (d *PriceDecoder) ReadUint16() (uint16, error) {
+	buffer, err := d.ReadBytes(2)
+	if nil != err { return 0, err }
+	return binary.LittleEndian.Uint16(buffer), nil
+}
+
+/**
+ * 读取小端序的uint32
+ */
+func (d *PriceDecoder) ReadUint32() (uint32, error) {
+	buffer, err := d.ReadBytes(4)
+	if nil != err { return 0, err }
+	return binary.LittleEndian.Uint32(buffer), nil
 }
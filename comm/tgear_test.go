@@ -2,6 +2,8 @@ package comm
 
 import (
 	"testing"
+	"math/rand"
+	"testing/quick"
 	"encoding/hex"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -24,4 +26,60 @@ func TestBufferToDouble(t *testing.T) {
 		So(resultList[2], ShouldEqual, -12.0)
 		So(resultList[5], ShouldEqual, 14999360.0)
 	})
-}
\ No newline at end of file
+}
+
+func TestDoubleToBuf(t *testing.T) {
+	Convey("测试价格数据的加密", t, func() {
+		So(hex.EncodeToString(DoubleToBuf(940.0)), ShouldEqual, "ac0e")
+		So(hex.EncodeToString(DoubleToBuf(-16.0)), ShouldEqual, "50")
+		So(hex.EncodeToString(DoubleToBuf(0)), ShouldEqual, "00")
+	})
+
+	Convey("测试价格数据加密与解密互逆", t, func() {
+		roundTrip := func(value int64) bool {
+			value = value % (1 << 52)   // float64 可精确表示的范围
+			buffer := DoubleToBuf(float64(value))
+			length, result := BufferToDouble(buffer)
+			return length == len(buffer) && result == float64(value)
+		}
+		So(quick.Check(roundTrip, &quick.Config{MaxCount: 10000}), ShouldBeNil)
+	})
+}
+
+func TestPriceDecoder(t *testing.T) {
+	buffer, _ := hex.DecodeString("AC0E504C246180FDA60EEC0EA0BC4D978C0204880F4EB7901EA9AB2F019C8E044100")
+
+	Convey("测试顺序解析价格数据", t, func() {
+		var resultList []float64
+		decoder := NewPriceDecoder(buffer)
+		for decoder.Remaining() > 0 {
+			result, err := decoder.Next()
+			So(err, ShouldBeNil)
+			resultList = append(resultList, result)
+		}
+
+		So(resultList[0], ShouldEqual, 940.0)
+		So(resultList[5], ShouldEqual, 14999360.0)
+
+		_, err := decoder.Next()
+		So(err, ShouldEqual, ErrPriceTruncated)
+	})
+
+	Convey("测试被截断的数据不会panic", t, func() {
+		truncated := func(data []byte) bool {
+			decoder := NewPriceDecoder(data)
+			for {
+				if _, err := decoder.Next(); nil != err { return err == ErrPriceTruncated }
+			}
+		}
+
+		// 最后一个字节带有后续标记
+		So(truncated([]byte{0xAC}), ShouldBeTrue)
+		So(quick.Check(truncated, &quick.Config{MaxCount: 10000, Rand: rand.New(rand.NewSource(1))}), ShouldBeNil)
+
+		decoder := NewPriceDecoder([]byte{0x01})
+		So(decoder.Skip(2), ShouldEqual, ErrPriceTruncated)
+		_, err := decoder.ReadUint32()
+		So(err, ShouldEqual, ErrPriceTruncated)
+	})
+}
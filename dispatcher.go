@@ -1,40 +1,213 @@
 package ctdx
 
 import (
+	"os"
 	"sync"
+	"context"
 
 	"github.com/datochan/gcom/cnet"
 
 	pkg "github.com/datochan/ctdx/packet"
 )
 
+const (
+	// 请求索引的分配区间, 索引为0的请求在组包时会随机生成 0~0x7FFE 的索引, 0xFFFF 用作结束标识, 都不在区间内
+	futureIndexMin = 0x8000
+	futureIndexMax = 0xFFFE
+)
+
+/**
+ * 单个请求的应答
+ */
+type Future struct {
+	EventId  uint16
+	Index    uint16
+	callback func(pkg.ResponseNode)   // 不为空时收到应答后先调用, 再通过done通知
+	done     chan pkg.ResponseNode
+	conn     *connDone                // 发送请求的连接的断开通知
+}
+
+/**
+ * 收到应答时可读
+ */
+func (f *Future) Done() <-chan pkg.ResponseNode {
+	return f.done
+}
+
+/**
+ * 阻塞等待应答
+ */
+func (f *Future) Wait() pkg.ResponseNode {
+	return <-f.done
+}
+
 type CTdxDispatcher struct {
 	*cnet.Dispatcher
 	rwlock     sync.RWMutex    // 写互斥避免并发状态下相互干扰
+
+	pendingLock sync.Mutex
+	indexSlots  chan struct{}      // 已分配的索引, 索引用尽时等待其它请求完成
+	pending     map[uint16]*Future // 等待应答的请求, 以请求索引为key
+	cancelled   map[uint16]uint16  // 已取消的请求索引及其事件标识, 迟到的应答直接丢弃
+	nextIndex   uint16
+	onRelease   func()             // 请求收到应答或被取消时调用, 用于释放发送窗口
+	onUnknown   func(pkg.ResponseNode)  // 收到没有对应请求也没有处理过程的应答时调用
+	replaying   map[uint16]pkg.RequestNode  // 回放封包记录时已回放的请求, 以请求索引为key
 }
 
 /**
  * 事件分发器
  */
 func NewCTdxDispatcher() *CTdxDispatcher {
-	return &CTdxDispatcher{Dispatcher: cnet.NewDispatcher()}
+	return &CTdxDispatcher{Dispatcher: cnet.NewDispatcher(),
+		indexSlots: make(chan struct{}, futureIndexMax-futureIndexMin+1),
+		pending: make(map[uint16]*Future), cancelled: make(map[uint16]uint16), nextIndex: futureIndexMin}
+}
+
+/**
+ * 为请求分配唯一的索引并登记为等待应答, 所有索引都在使用时等待其它请求完成或 ctx 结束
+ * callback 为空时通过返回的 Future 获取应答, 否则在收到应答时直接调用
+ */
+func (p *CTdxDispatcher) Register(ctx context.Context, reqNode *pkg.RequestNode, callback func(pkg.ResponseNode)) (*Future, error) {
+	select {
+	case p.indexSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+
+	// 优先使用未被取消过的索引, 避免已取消请求的迟到应答被当作新请求的应答
+	reusable := -1
+	for count := 0; count <= futureIndexMax-futureIndexMin; count++ {
+		index := p.nextIndex
+		if p.nextIndex >= futureIndexMax { p.nextIndex = futureIndexMin } else { p.nextIndex++ }
+
+		if _, exists := p.pending[index]; exists { continue }
+		if _, exists := p.cancelled[index]; exists {
+			if 0 > reusable { reusable = int(index) }
+			continue
+		}
+
+		reusable = int(index)
+		break
+	}
+	reqNode.Index = uint16(reusable)
+	delete(p.cancelled, reqNode.Index)
+
+	future := &Future{EventId: reqNode.EventId, Index: reqNode.Index, callback: callback,
+		done: make(chan pkg.ResponseNode, 1)}
+	p.pending[future.Index] = future
+
+	return future, nil
+}
+
+/**
+ * 取消等待应答的请求, 之后收到的该请求的应答将被丢弃
+ */
+func (p *CTdxDispatcher) Cancel(future *Future) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+
+	if current, exists := p.pending[future.Index]; exists && current == future {
+		delete(p.pending, future.Index)
+		p.cancelled[future.Index] = future.EventId
+		<-p.indexSlots
+		if nil != p.onRelease { p.onRelease() }
+	}
+}
+
+/**
+ * 等待应答的请求数量
+ */
+func (p *CTdxDispatcher) Pending() int {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	return len(p.pending)
+}
+
+/**
+ * 取出与应答对应的请求, 应答属于已取消的请求时 dropped 为 true
+ */
+func (p *CTdxDispatcher) takeFuture(respNode pkg.ResponseNode) (future *Future, dropped bool) {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+
+	if eventId, exists := p.cancelled[respNode.Index]; exists && eventId == respNode.EventId {
+		delete(p.cancelled, respNode.Index)
+		return nil, true
+	}
+
+	future, exists := p.pending[respNode.Index]
+	if !exists || future.EventId != respNode.EventId { return nil, false }
+
+	delete(p.pending, respNode.Index)
+	<-p.indexSlots
+	if nil != p.onRelease { p.onRelease() }
+	return future, false
 }
 
 /**
  * 事件处理过程
  */
 func (p *CTdxDispatcher) HandleProc(session cnet.ISession, packet interface{}) {
-	p.rwlock.RLock()
-	defer p.rwlock.RUnlock()
-
 	respNode := packet.(pkg.ResponseNode)
 	if respNode.EventId <= 0 { return }
 
+	// 优先交给发出请求时登记的 Future
+	future, dropped := p.takeFuture(respNode)
+	if dropped { return }
+	if nil != future {
+		if nil != future.callback { future.callback(respNode) }
+		future.done <- respNode
+		return
+	}
+
+	p.rwlock.RLock()
+	defer p.rwlock.RUnlock()
+
 	handlerProc := p.GetHandler(uint32(respNode.EventId))
 	if nil == handlerProc {
+		if nil != p.onUnknown { p.onUnknown(respNode) }
 		UnknownPkgHandler(session, packet)
 		return
 	}
 
 	handlerProc(session, packet)
 }
+
+/**
+ * 将封包记录文件中的应答封包按顺序交给已注册的处理过程, 用于重现解析问题
+ * 处理过程中可通过 ReplayRequest 获取应答对应的请求
+ */
+func (p *CTdxDispatcher) Replay(capturePath string) error {
+	file, err := os.Open(capturePath)
+	if nil != err { return err }
+	defer file.Close()
+
+	recordList, err := pkg.ReadCapture(file)
+	if nil != err { return err }
+
+	p.replaying = make(map[uint16]pkg.RequestNode)
+	defer func() { p.replaying = nil }()
+
+	for _, record := range recordList {
+		if pkg.CaptureRequest == record.Direction {
+			p.replaying[record.Request.Index] = record.Request
+			continue
+		}
+		p.HandleProc(nil, record.Response)
+	}
+
+	return nil
+}
+
+/**
+ * 回放封包记录时获取应答对应的请求, 按请求索引及事件标识匹配
+ */
+func (p *CTdxDispatcher) ReplayRequest(respNode pkg.ResponseNode) (pkg.RequestNode, bool) {
+	reqNode, exists := p.replaying[respNode.Index]
+	if !exists || reqNode.EventId != respNode.EventId { return pkg.RequestNode{}, false }
+	return reqNode, true
+}
@@ -0,0 +1,116 @@
+package ctdx
+
+import (
+	"time"
+	"context"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/gcom/cnet"
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试按请求索引分发应答", t, func() {
+		dispatcher := NewCTdxDispatcher()
+
+		firstNode := pkg.GenerateStockDayItem(0, "000001", 20180102, 20180105, 0)
+		secondNode := pkg.GenerateStockDayItem(1, "600000", 20180102, 20180105, 0)
+		first, err := dispatcher.Register(ctx, &firstNode, nil)
+		So(err, ShouldBeNil)
+		second, err := dispatcher.Register(ctx, &secondNode, nil)
+		So(err, ShouldBeNil)
+
+		So(firstNode.Index, ShouldEqual, first.Index)
+		So(first.Index, ShouldNotEqual, second.Index)
+		So(first.Index, ShouldBeGreaterThanOrEqualTo, futureIndexMin)
+		So(dispatcher.Pending(), ShouldEqual, 2)
+
+		// 相同事件标识的应答乱序到达
+		dispatcher.HandleProc(nil, pkg.ResponseNode{pkg.ResponseHeader{Index: second.Index,
+			EventId: secondNode.EventId}, []byte{0x02}, nil})
+		dispatcher.HandleProc(nil, pkg.ResponseNode{pkg.ResponseHeader{Index: first.Index,
+			EventId: firstNode.EventId}, []byte{0x01}, nil})
+
+		So(first.Wait().RawData, ShouldResemble, []byte{0x01})
+		So(second.Wait().RawData, ShouldResemble, []byte{0x02})
+		So(dispatcher.Pending(), ShouldEqual, 0)
+
+		Convey("测试应答回调", func() {
+			var received []byte
+			reqNode := pkg.GenerateMarketStockCount(0)
+			future, err := dispatcher.Register(ctx, &reqNode, func(respNode pkg.ResponseNode) {
+				received = respNode.RawData.([]byte)
+			})
+			So(err, ShouldBeNil)
+
+			dispatcher.HandleProc(nil, pkg.ResponseNode{pkg.ResponseHeader{Index: future.Index,
+				EventId: reqNode.EventId}, []byte{0x03}, nil})
+			So(received, ShouldResemble, []byte{0x03})
+			So(future.Wait().RawData, ShouldResemble, []byte{0x03})
+		})
+
+		Convey("测试取消后丢弃迟到的应答", func() {
+			var handled int
+			reqNode := pkg.GenerateMarketStockCount(0)
+			future, err := dispatcher.Register(ctx, &reqNode, nil)
+			So(err, ShouldBeNil)
+			dispatcher.Cancel(future)
+			dispatcher.AddHandler(uint32(reqNode.EventId), func(cnet.ISession, interface{}) { handled++ })
+			So(dispatcher.Pending(), ShouldEqual, 0)
+
+			// 取消过的索引不会马上被新的请求使用
+			nextNode := pkg.GenerateMarketStockCount(0)
+			next, err := dispatcher.Register(ctx, &nextNode, nil)
+			So(err, ShouldBeNil)
+			So(next.Index, ShouldNotEqual, future.Index)
+
+			respNode := pkg.ResponseNode{pkg.ResponseHeader{Index: future.Index, EventId: reqNode.EventId}, []byte{0x04}, nil}
+			dispatcher.HandleProc(nil, respNode)
+			So(handled, ShouldEqual, 0)
+			So(len(next.Done()), ShouldEqual, 0)
+
+			// 再次收到相同索引的应答时按事件标识分发
+			dispatcher.HandleProc(nil, respNode)
+			So(handled, ShouldEqual, 1)
+		})
+	})
+
+	Convey("测试索引用尽时等待", t, func() {
+		dispatcher := NewCTdxDispatcher()
+
+		var futureList []*Future
+		for idx := futureIndexMin; idx <= futureIndexMax; idx++ {
+			reqNode := pkg.GenerateMarketStockCount(0)
+			future, err := dispatcher.Register(ctx, &reqNode, nil)
+			So(err, ShouldBeNil)
+			futureList = append(futureList, future)
+		}
+		So(dispatcher.Pending(), ShouldEqual, futureIndexMax-futureIndexMin+1)
+
+		// 等待时可以被取消
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		reqNode := pkg.GenerateMarketStockCount(0)
+		_, err := dispatcher.Register(timeoutCtx, &reqNode, nil)
+		So(err, ShouldEqual, ErrRequestTimeout)
+
+		// 其它请求完成后继续分配索引
+		registered := make(chan *Future, 1)
+		go func() {
+			future, _ := dispatcher.Register(ctx, &reqNode, nil)
+			registered <- future
+		}()
+		dispatcher.Cancel(futureList[0])
+
+		var future *Future
+		select {
+		case future = <-registered:
+		case <-time.After(time.Second):
+		}
+		So(future, ShouldNotBeNil)
+		So(dispatcher.Pending(), ShouldEqual, futureIndexMax-futureIndexMin+1)
+	})
+}
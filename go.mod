@@ -0,0 +1,11 @@
+module github.com/datochan/ctdx
+
+go 1.16
+
+require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/klauspost/compress v1.2.1
+	github.com/kniren/gota v0.9.0
+	github.com/smartystreets/goconvey v1.6.3
+	github.com/stretchr/testify v1.2.1
+)
@@ -4,6 +4,7 @@ import (
 	"os"
 	"fmt"
 	"bytes"
+	"errors"
 	"strings"
 	"strconv"
 	"encoding/hex"
@@ -14,7 +15,8 @@ import (
 	"github.com/datochan/gcom/utils"
 	"github.com/datochan/gcom/logger"
 	"github.com/kniren/gota/dataframe"
-    pkg "test_tdx/ctdx/packet"
+    "github.com/datochan/ctdx/comm"
+    pkg "github.com/datochan/ctdx/packet"
 	gbytes "github.com/datochan/gcom/bytes"
 )
 
@@ -32,23 +34,39 @@ func UnknownPkgHandler(session cnet.ISession, packet interface{}) {
 	}
 }
 
+/**
+ * 收到没有对应请求也没有处理过程的应答, 设备注册及公告等 UnknownPkgHandler 能识别的封包除外
+ */
+func (client *TdxClient) onUnknownPacket(respNode pkg.ResponseNode) {
+	switch respNode.EventId {
+	case 0x0B, 0x0FDB: return
+	}
+
+	client.metrics.unknownPacket()
+	if 0 == len(client.UnknownPacketPath) { return }
+
+	dumpPath, err := pkg.SavePacketDump(client.UnknownPacketPath, respNode)
+	if nil != err {
+		logger.Error("保存未知封包(event:0x%04X)失败: %v", respNode.EventId, err)
+		return
+	}
+	logger.Info("未知封包(event:0x%04X, cmd:0x%04X)已保存到 %s", respNode.EventId, respNode.CmdId, dumpPath)
+}
+
 
 /**
  * 接收市场行情的初始数据
  */
 func (client *TdxClient) OnMarketInitInfo(session cnet.ISession, packet interface{}){
-	var newBuffer bytes.Buffer
 	var notice pkg.MarketInitInfo
 
 	respNode := packet.(pkg.ResponseNode)
-
-	newBuffer.Write(respNode.RawData.([]byte))
-	err := binary.Read(&newBuffer, binary.LittleEndian, &notice)
+	_, err := pkg.Unmarshal(respNode.RawData.([]byte), &notice)
 
 	if nil != err { return }
 
-	client.lastTrade.ServerName = utils.ConvertTo(gbytes.BytesToString(notice.ServerName[:]), "gbk", "utf8")
-	client.lastTrade.Domain = gbytes.BytesToString(notice.DomainUrl[:])
+	client.lastTrade.ServerName = notice.ServerName
+	client.lastTrade.Domain = notice.DomainUrl
 	client.lastTrade.SHDate = notice.DateSH
 	client.lastTrade.SHFlag = notice.LastSHFlag
 
@@ -131,121 +149,133 @@ func (client *TdxClient) onSTStocks(){
 }
 
 /**
- * 获取股票基础信息
+ * 解析股票基础信息
  */
-func (client *TdxClient) OnStockBase(session cnet.ISession, packet interface{}){
-	var newBuffer bytes.Buffer
-	var stockItem pkg.StockBaseItem
+func decodeStockBase(market int, rawData []byte) ([]StockBaseModel, error) {
+	var stockBaseList pkg.StockBaseList
 	var stockList []StockBaseModel
 
-	market := 0
-
-	itemSize := utils.SizeStruct(pkg.StockBaseItem{})
-	respNode := packet.(pkg.ResponseNode)
-
-	if respNode.CmdId == 0x6E { market = 1 }
-
-	littleEndianBuffer := gbytes.NewLittleEndianStream(respNode.RawData.([]byte))
-
-	stockCount, _ := littleEndianBuffer.ReadUint16()  // 读取股票数量
+	if _, err := pkg.Unmarshal(rawData, &stockBaseList); nil != err { return nil, err }
 
-	for idx :=0; idx < int(stockCount); idx++ {
-		tmpBuffer, _ := littleEndianBuffer.ReadBuff(itemSize)
-		newBuffer.Write(tmpBuffer)
-
-		binary.Read(&newBuffer, binary.LittleEndian, &stockItem)
-
-		stockModel := StockBaseModel{gbytes.BytesToString(stockItem.Code[:]),
-			utils.ConvertTo(gbytes.BytesToString(stockItem.Name[:]), "gbk", "utf8"), market,
+	for _, stockItem := range stockBaseList.Items {
+		stockModel := StockBaseModel{stockItem.Code, stockItem.Name, market,
 			int(stockItem.Unknown1), int(stockItem.Unknown2), int(stockItem.Unknown3),
 			float64(stockItem.Price), int(stockItem.Bonus1), int(stockItem.Bonus2)}
 
 		stockList = append(stockList, stockModel)
 	}
 
-	stockBaseDF := dataframe.LoadStructs(stockList)
+	return stockList, nil
+}
 
-	if nil != stockBaseDF.Err {
-		logger.Error("加载新的股票数据时发生错误: %v", stockBaseDF.Err)
+/**
+ * OnStockBase 等处理过程保存解析结果的位置
+ */
+func (client *TdxClient) replayResult() *ReplayResult {
+	if nil == client.replayed { client.replayed = new(ReplayResult) }
+	return client.replayed
+}
+
+/**
+ * 获取股票基础信息, 市场由应答的 CmdId 区分, 主要用于回放封包记录
+ */
+func (client *TdxClient) OnStockBase(session cnet.ISession, packet interface{}) {
+	respNode := packet.(pkg.ResponseNode)
+
+	market := 0
+	if pkg.GenerateMarketStockBase(1, 0).CmdId == respNode.CmdId { market = 1 }
+
+	stockList, err := decodeStockBase(market, respNode.RawData.([]byte))
+	if nil != err {
+		client.metrics.decodeFailed()
+		logger.Error("解析股票列表时发生错误: %v", err)
 		return
 	}
 
-	if 0 >= client.stockBaseDF.Nrow() {
-		client.stockBaseDF = stockBaseDF
-	} else {
-		client.stockBaseDF = client.stockBaseDF.RBind(stockBaseDF)
-	}
+	result := client.replayResult()
+	result.StockList = append(result.StockList, stockList...)
+}
 
-	if client.stockBaseDF.Nrow() >= int(client.lastTrade.SZCount + client.lastTrade.SHCount) {
-		// 更新结束
-		client.stockBaseDF.SetNames("code", "name", "market", "unknown1", "unknown2", "unknown3", "price", "bonus1", "bonus2")
-        stockBasePath := fmt.Sprintf("%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockList)
-        utils.WriteCSV(stockBasePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockBaseDF)
-        uptime := client.GetLastTradeDate()
-        fdir := filepath.Join(filepath.Dir(stockBasePath), "stocks")
-        fname := fmt.Sprintf("%d.csv", uptime)
-        backupPath := filepath.Join(fdir, fname)
-        utils.WriteCSV(backupPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockBaseDF)
-		client.dispatcher.DelHandler(uint32(respNode.EventId))
-		client.onSTStocks()
-		client.Finished <- nil
-	}
+/**
+ * 保存股票基础信息
+ */
+func (client *TdxClient) saveStockBase(){
+	client.stockBaseDF.SetNames("code", "name", "market", "unknown1", "unknown2", "unknown3", "price", "bonus1", "bonus2")
+	stockBasePath := fmt.Sprintf("%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockList)
+	utils.WriteCSV(stockBasePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockBaseDF)
+	uptime := client.GetLastTradeDate()
+	fdir := filepath.Join(filepath.Dir(stockBasePath), "stocks")
+	fname := fmt.Sprintf("%d.csv", uptime)
+	backupPath := filepath.Join(fdir, fname)
+	utils.WriteCSV(backupPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockBaseDF)
 }
 
 /**
- * 获取股票权息数据
+ * 解析股票权息数据
  */
-func (client *TdxClient) OnStockBonus(session cnet.ISession, packet interface{}){
-	var newBuffer bytes.Buffer
-	var bonusItem pkg.StockBonusItem
+func decodeStockBonus(rawData []byte) ([]StockBonusModel, error) {
+	var stockBonusList pkg.StockBonusList
 	var bonusList []StockBonusModel
-	var finisedCodes []string
-    var code string
 
-	respNode := packet.(pkg.ResponseNode)
-	itemSize := utils.SizeStruct(pkg.StockBonusItem{})
-	littleEndianBuffer := gbytes.NewLittleEndianStream(respNode.RawData.([]byte))
-
-	stockCount, _ := littleEndianBuffer.ReadUint16()  // 读取股票数量
-
-	//logger.Info("\t收到 %d 只股票的权息数据...", stockCount)
-
-	for stockIdx :=0; stockIdx < int(stockCount); stockIdx++ {
-		littleEndianBuffer.ReadBuff(7)  // 跳过股票代码与市场标识
-		bonusCount, _ := littleEndianBuffer.ReadUint16()  // 某只股票的权息条数
-		for bonusIdx:=0;bonusIdx<int(bonusCount);bonusIdx++ {
-			tmpBuffer, _ := littleEndianBuffer.ReadBuff(itemSize)
-			newBuffer.Write(tmpBuffer)
-			binary.Read(&newBuffer, binary.LittleEndian, &bonusItem)
-            code = gbytes.BytesToString(bonusItem.Code[:])
-			bonusModel := StockBonusModel{code, int(bonusItem.Date),
-                int(bonusItem.Market), int(bonusItem.Type),
+	if _, err := pkg.Unmarshal(rawData, &stockBonusList); nil != err { return nil, err }
+
+	for _, bonusGroup := range stockBonusList.Groups {
+		for _, bonusItem := range bonusGroup.Items {
+			bonusModel := StockBonusModel{bonusItem.Code, int(bonusItem.Date),
+				int(bonusItem.Market), int(bonusItem.Type),
 				float64(bonusItem.Money), float64(bonusItem.Price),
-                float64(bonusItem.Count), float64(bonusItem.Rate)}
+				float64(bonusItem.Count), float64(bonusItem.Rate)}
 			bonusList = append(bonusList, bonusModel)
 		}
-		finisedCodes = append(finisedCodes, code)
-	}
-    if len(bonusList) > 0 {
-        // 更新结束
-        bonusDF := dataframe.LoadStructs(bonusList)
-	    if nil != bonusDF.Err {
-            logger.Error(fmt.Sprintf("加载权息数据时发生错误:%v", bonusDF.Err))
-            return
-	    }
-	    if 0 >= client.stockbonusDF.Nrow() {
-            client.stockbonusDF = bonusDF
-	    } else {
-            client.stockbonusDF = client.stockbonusDF.RBind(bonusDF)
-	    }
-    }
-	if stockBonusFinishedIdx != respNode.Index {
-        for _, code = range finisedCodes{
-		    client.bonusFinishedChan <- code
-        }
+	}
+
+	return bonusList, nil
+}
+
+/**
+ * 获取股票权息数据
+ */
+func (client *TdxClient) onStockBonus(respNode pkg.ResponseNode){
+	bonusList, err := decodeStockBonus(respNode.RawData.([]byte))
+	if nil != err {
+		client.metrics.decodeFailed()
+		logger.Error(fmt.Sprintf("解析权息数据时发生错误:%v", err))
+	}
+	if 0 >= len(bonusList) { return }
+
+	bonusDF := dataframe.LoadStructs(bonusList)
+	if nil != bonusDF.Err {
+		logger.Error(fmt.Sprintf("加载权息数据时发生错误:%v", bonusDF.Err))
 		return
 	}
-	client.dispatcher.DelHandler(uint32(respNode.EventId))
+	if 0 >= client.stockbonusDF.Nrow() {
+		client.stockbonusDF = bonusDF
+	} else {
+		client.stockbonusDF = client.stockbonusDF.RBind(bonusDF)
+	}
+}
+
+/**
+ * 获取股票权息数据, 主要用于回放封包记录
+ */
+func (client *TdxClient) OnStockBonus(session cnet.ISession, packet interface{}) {
+	respNode := packet.(pkg.ResponseNode)
+
+	bonusList, err := decodeStockBonus(respNode.RawData.([]byte))
+	if nil != err {
+		client.metrics.decodeFailed()
+		logger.Error("解析权息数据时发生错误: %v", err)
+		return
+	}
+
+	result := client.replayResult()
+	result.Bonus = append(result.Bonus, bonusList...)
+}
+
+/**
+ * 保存权息数据
+ */
+func (client *TdxClient) saveStockBonus(){
 	client.stockbonusDF.SetNames("code", "date", "market", "type", "money", "price", "count", "rate")
     bonusPath := fmt.Sprintf("%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockBonus)
     utils.WriteCSV(bonusPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockbonusDF)
@@ -255,22 +285,18 @@ func (client *TdxClient) OnStockBonus(session cnet.ISession, packet interface{})
     fname := fmt.Sprintf("%d.csv", uptime)
     backupPath := filepath.Join(fdir, fname)
     utils.WriteCSV(backupPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &client.stockbonusDF)
-
-	client.dispatcher.DelHandler(uint32(respNode.EventId))
-	client.Finished <- nil
-	return
 }
 
-func (client *TdxClient) onStockDayHistory(market int, code string, stockLength int, littleEndianBuffer *gbytes.LittleEndianStreamImpl) dataframe.DataFrame {
-	var newBuffer bytes.Buffer
-	var stockDayItem pkg.StockDayItem
+/**
+ * 解析日线数据
+ */
+func decodeStockDays(market int, code string, rawData []byte) ([]StockDayModel, error) {
+	var stockDayList pkg.StockDayList
 	var stockDaysList []StockDayModel
-	itemSize := utils.SizeStruct(pkg.StockMinsItem{})
-	stockCount := int(stockLength)/itemSize
-	for idx:=0; idx<stockCount; idx++{
-		tmpBuffer, _ := littleEndianBuffer.ReadBuff(itemSize)
-		newBuffer.Write(tmpBuffer)
-		binary.Read(&newBuffer, binary.LittleEndian, &stockDayItem)
+
+	if _, err := pkg.Unmarshal(rawData, &stockDayList); nil != err { return nil, err }
+
+	for _, stockDayItem := range stockDayList.Items {
 		stockDayModel := StockDayModel{market, code, int(stockDayItem.Date),
 			float64(stockDayItem.Open)/100.0,float64(stockDayItem.Low)/100.0,
 			float64(stockDayItem.High)/100.0,float64(stockDayItem.Close)/100.0,
@@ -278,26 +304,42 @@ func (client *TdxClient) onStockDayHistory(market int, code string, stockLength
 
 		stockDaysList = append(stockDaysList, stockDayModel)
 	}
-	if 0 >= len(stockDaysList) {
-		return dataframe.DataFrame{Err: fmt.Errorf("没有任何行情数据")}
-	}
-	return dataframe.LoadStructs(stockDaysList)
+
+	return stockDaysList, nil
 }
 
-func (client *TdxClient) onStockMinsHistory(market int, code string, stockLength int, littleEndianBuffer *gbytes.LittleEndianStreamImpl) dataframe.DataFrame {
-	var newBuffer bytes.Buffer
-	var stockMinsItem pkg.StockMinsItem
-	var stockMinsList []StockMinsModel
+/**
+ * 解析指数日线数据, 比个股多出涨跌家数
+ */
+func decodeIndexDays(market int, code string, rawData []byte) ([]IndexDayModel, error) {
+	var indexDayList pkg.IndexDayList
+	var indexDaysList []IndexDayModel
 
-	itemSize := utils.SizeStruct(pkg.StockMinsItem{})
-	stockCount := int(stockLength)/itemSize
+	if _, err := pkg.Unmarshal(rawData, &indexDayList); nil != err { return nil, err }
 
-	for idx:=0; idx<stockCount; idx++{
-		tmpBuffer, _ := littleEndianBuffer.ReadBuff(itemSize)
-		newBuffer.Write(tmpBuffer)
+	for _, indexDayItem := range indexDayList.Items {
+		indexDayModel := IndexDayModel{market, code, int(indexDayItem.Date),
+			float64(indexDayItem.Open)/100.0,float64(indexDayItem.Low)/100.0,
+			float64(indexDayItem.High)/100.0,float64(indexDayItem.Close)/100.0,
+			int(indexDayItem.Volume),float64(indexDayItem.Amount),
+			int(indexDayItem.UpCount),int(indexDayItem.DownCount)}
 
-		binary.Read(&newBuffer, binary.LittleEndian, &stockMinsItem)
+		indexDaysList = append(indexDaysList, indexDayModel)
+	}
 
+	return indexDaysList, nil
+}
+
+/**
+ * 解析分钟线数据
+ */
+func decodeStockMins(market int, code string, rawData []byte) ([]StockMinsModel, error) {
+	var stockMinsList pkg.StockMinsList
+	var stockMinsModels []StockMinsModel
+
+	if _, err := pkg.Unmarshal(rawData, &stockMinsList); nil != err { return nil, err }
+
+	for _, stockMinsItem := range stockMinsList.Items {
 		nYear := int(stockMinsItem.Date) / 2048 + 2004
 		nMonth := int(stockMinsItem.Date % 2048 / 100)
 		nDay := int(stockMinsItem.Date % 2048 % 100)
@@ -310,79 +352,410 @@ func (client *TdxClient) onStockMinsHistory(market int, code string, stockLength
 			float64(stockMinsItem.High),float64(stockMinsItem.Close),
 			int(stockMinsItem.Volume)/100,float64(stockMinsItem.Amount)}
 
-		stockMinsList = append(stockMinsList, stockMinsModel)
+		stockMinsModels = append(stockMinsModels, stockMinsModel)
 	}
-	if 0 >= len(stockMinsList) {
-		return dataframe.DataFrame{Err: fmt.Errorf("没有任何行情数据")}
+
+	return stockMinsModels, nil
+}
+
+// 请求的区间内没有行情数据, 如停牌或尚未上市, 不算作错误
+var errNoBars = errors.New("没有任何行情数据")
+
+func (client *TdxClient) onStockDayHistory(market int, code string, rawData []byte) dataframe.DataFrame {
+	stockDaysList, err := decodeStockDays(market, code, rawData)
+	if nil != err {
+		client.metrics.decodeFailed()
+		return dataframe.DataFrame{Err: fmt.Errorf("解析 %d%s 的日线数据时发生错误: %v", market, code, err)}
+	}
+	if 0 >= len(stockDaysList) { return dataframe.DataFrame{Err: errNoBars} }
+	return dataframe.LoadStructs(stockDaysList)
+}
+
+func (client *TdxClient) onIndexDayHistory(market int, code string, rawData []byte) dataframe.DataFrame {
+	indexDaysList, err := decodeIndexDays(market, code, rawData)
+	if nil != err {
+		client.metrics.decodeFailed()
+		return dataframe.DataFrame{Err: fmt.Errorf("解析 %d%s 的指数日线数据时发生错误: %v", market, code, err)}
+	}
+	if 0 >= len(indexDaysList) { return dataframe.DataFrame{Err: errNoBars} }
+	return dataframe.LoadStructs(indexDaysList)
+}
+
+func (client *TdxClient) onStockMinsHistory(market int, code string, rawData []byte) dataframe.DataFrame {
+	stockMinsModels, err := decodeStockMins(market, code, rawData)
+	if nil != err {
+		client.metrics.decodeFailed()
+		return dataframe.DataFrame{Err: fmt.Errorf("解析 %d%s 的分钟线数据时发生错误: %v", market, code, err)}
 	}
-	return dataframe.LoadStructs(stockMinsList)
+	if 0 >= len(stockMinsModels) { return dataframe.DataFrame{Err: errNoBars} }
+	return dataframe.LoadStructs(stockMinsModels)
 }
 
 /**
  * 保存行情数据
  */
-func (client *TdxClient) historySaveFile(df dataframe.DataFrame, stocksPath string) {
+func (client *TdxClient) historySaveFile(df dataframe.DataFrame, stocksPath string) error {
 	isExist, _ := utils.FileExists(stocksPath)
 	if ! isExist {
-		utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &df)
-	} else {
-		utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, &df, dataframe.WriteHeader(false))
+		return utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &df)
 	}
+	return utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, &df, dataframe.WriteHeader(false))
 }
 
 /**
- * 接收行情数据
+ * 为旧格式(没有涨跌家数)的指数行情文件补充空的 up, down 列, 已有的记录保持不变
  */
-func (client *TdxClient) OnStockHistory(session cnet.ISession, packet interface{}) {
+func (client *TdxClient) migrateIndexBars(stocksPath string, df dataframe.DataFrame) (dataframe.DataFrame, error) {
+	emptyList := make([]string, df.Nrow())
+	df = df.Mutate(series.New(emptyList, series.Int, "up")).Mutate(series.New(emptyList, series.Int, "down"))
+	if nil != df.Err { return df, df.Err }
+
+	return df, utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &df)
+}
+
+/**
+ * 去掉行情文件中的最后一条记录
+ */
+func (client *TdxClient) dropLastBar(stocksPath string, df dataframe.DataFrame) error {
+	rows := make([]int, df.Nrow()-1)
+	for idx := range rows { rows[idx] = idx }
+
+	remainDF := df.Subset(rows)
+	if nil != remainDF.Err { return remainDF.Err }
+	return utils.WriteCSV(stocksPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, &remainDF)
+}
+
+/**
+ * 接收行情数据并保存, market, code, period 为发出请求时的参数
+ * 解析或保存失败时返回错误, 区间内没有行情数据时返回nil
+ */
+func (client *TdxClient) onStockHistory(period pkg.KLinePeriod, market int, strCode string, respNode pkg.ResponseNode) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
-			fmt.Printf("panic recover! p: %v", p)
+			err = fmt.Errorf("处理 %d%s 的K线数据时发生错误: %v", market, strCode, p)
 		}
 	}()
+
+	// 收到盘后行情数据
+	rawData := respNode.RawData.([]byte)
+
+	fileName := fmt.Sprintf("%d%s.csv", market, strCode)
+	stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath, client.barsDir(period), fileName)
+
+	var df dataframe.DataFrame
+	var names []string
+	switch {
+	case period.IsMinute():
+		df = client.onStockMinsHistory(market, strCode, rawData)
+		names = []string{"market", "code", "date", "time", "open", "low", "high", "close", "volume", "amount"}
+	case comm.IsIndex(market, strCode):
+		// 指数数据额外带有涨跌家数
+		df = client.onIndexDayHistory(market, strCode, rawData)
+		names = []string{"market", "code", "date", "open", "low", "high", "close", "volume", "amount", "up", "down"}
+	default:
+		df = client.onStockDayHistory(market, strCode, rawData)
+		names = []string{"market", "code", "date", "open", "low", "high", "close", "volume", "amount"}
+	}
+
+	if errNoBars == df.Err { return nil }
+	if nil != df.Err { return df.Err }
+
+	df.SetNames(names...)
+	return client.historySaveFile(df, stocksPath)
+}
+
+/**
+ * 接收行情数据, 证券及周期取自回放封包记录时应答对应的请求, 主要用于回放封包记录
+ */
+func (client *TdxClient) OnStockHistory(session cnet.ISession, packet interface{}) {
 	respNode := packet.(pkg.ResponseNode)
-	if 0xffff == respNode.Index {
-		// 更新结束
-		client.dispatcher.DelHandler(uint32(respNode.EventId))
-		client.Finished <- nil
+
+	reqNode, exists := client.dispatcher.ReplayRequest(respNode)
+	if !exists {
+		logger.Error("没有找到K线应答(index:0x%04X)对应的请求", respNode.Index)
+		return
+	}
+
+	period, market, strCode, err := pkg.ParseStockBarsItem(reqNode)
+	if nil != err {
+		logger.Error("解析K线请求时发生错误: %v", err)
 		return
 	}
-	// 收到盘后行情数据
-	littleEndianBuffer := gbytes.NewLittleEndianStream(respNode.RawData.([]byte))
 
-	littleEndianBuffer.ReadUint16()                   // 略过标识符
-	stockLength, _ := littleEndianBuffer.ReadUint32() // 读取股票数量
+	rawData := respNode.RawData.([]byte)
+	result := client.replayResult()
+
+	switch {
+	case period.IsMinute():
+		var minsList []StockMinsModel
+		minsList, err = decodeStockMins(int(market), strCode, rawData)
+		result.MinsBars = append(result.MinsBars, minsList...)
+	case comm.IsIndex(int(market), strCode):
+		var indexList []IndexDayModel
+		indexList, err = decodeIndexDays(int(market), strCode, rawData)
+		result.IndexBars = append(result.IndexBars, indexList...)
+	default:
+		var dayList []StockDayModel
+		dayList, err = decodeStockDays(int(market), strCode, rawData)
+		result.DayBars = append(result.DayBars, dayList...)
+	}
 
-	idx := utils.FindInStringSlice("code", client.stockBaseDF.Names())
-	strCode := client.stockBaseDF.Elem(int(respNode.Index-1), idx).String()
-	idx = utils.FindInStringSlice("market", client.stockBaseDF.Names())
-	market, _ := client.stockBaseDF.Elem(int(respNode.Index-1), idx).Int()
+	if nil != err {
+		client.metrics.decodeFailed()
+		logger.Error("解析 %d%s 的K线数据时发生错误: %v", market, strCode, err)
+	}
+}
 
-	//logger.Info("\t已收到 %d%s 的盘后行情数据...", market, strCode)
 
-	fileName := fmt.Sprintf("%d%s.csv", market, strCode)
+/**
+ * 格式化实时行情中的服务器时间
+ * 如: 14999360 => 14:59:57.696
+ */
+func formatQuoteTime(rawTime int) string {
+	strTime := fmt.Sprintf("%d", rawTime)
+	if len(strTime) < 7 { return strTime }
+
+	hour := strTime[:len(strTime)-6]
+	minute, _ := strconv.Atoi(strTime[len(strTime)-6:len(strTime)-4])
+	if minute < 60 {
+		second, _ := strconv.Atoi(strTime[len(strTime)-4:])
+		return fmt.Sprintf("%s:%02d:%06.3f", hour, minute, float64(second)*60/10000.0)
+	}
+
+	rest, _ := strconv.Atoi(strTime[len(strTime)-6:])
+	return fmt.Sprintf("%s:%02d:%06.3f", hour, rest*60/1000000, float64(rest*60%1000000)*60/1000000.0)
+}
 
-	if respNode.CmdId == pkg.GenerateStockDayItem(0, "", 0, 0, 0).CmdId {
-		df := client.onStockDayHistory(market, strCode, int(stockLength), littleEndianBuffer)
-		if nil != df.Err {
-			//logger.Info("\t接收行情 %d%s 的数据出错, Err: %v", market, strCode, df.Err)
-			return
+/**
+ * 解析实时行情数据
+ */
+func decodeSecurityQuotes(rawData []byte) ([]QuoteModel, error) {
+	var err error
+	var quoteList []QuoteModel
+
+	decoder := comm.NewPriceDecoder(rawData)
+	nextPrice := func() int {
+		if nil != err { return 0 }
+		var value int
+		value, err = decoder.NextInt()
+		return value
+	}
+
+	decoder.Skip(2) // 略过标识符
+	stockCount, err := decoder.ReadUint16()
+	if nil != err { return nil, err }
+
+	for idx := 0; idx < int(stockCount); idx++ {
+		var quote QuoteModel
+		var bids, asks [5]float64
+		var bidVols, askVols [5]int
+
+		var stockInfo []byte
+		stockInfo, err = decoder.ReadBytes(9) // 市场(1) + 代码(6) + 活跃度(2)
+		if nil != err { return quoteList, err }
+		quote.Market = int(stockInfo[0])
+		quote.Code = gbytes.BytesToString(stockInfo[1:7])
+
+		price := nextPrice()
+		quote.Price = float64(price)/100.0
+		quote.PreClose = float64(price+nextPrice())/100.0
+		quote.Open = float64(price+nextPrice())/100.0
+		quote.High = float64(price+nextPrice())/100.0
+		quote.Low = float64(price+nextPrice())/100.0
+		quote.ServerTime = formatQuoteTime(nextPrice())
+		nextPrice()  // 未知
+
+		quote.Volume = nextPrice()
+		quote.CurVolume = nextPrice()
+		if nil != err { return quoteList, err }
+
+		var amount uint32
+		amount, err = decoder.ReadUint32()
+		if nil != err { return quoteList, err }
+		quote.Amount = comm.IntToVolume(amount)
+
+		quote.SellVolume = nextPrice()
+		quote.BuyVolume = nextPrice()
+		nextPrice()  // 未知
+		nextPrice()  // 未知
+
+		for level := 0; level < 5; level++ {
+			bids[level] = float64(price+nextPrice())/100.0
+			asks[level] = float64(price+nextPrice())/100.0
+			bidVols[level] = nextPrice()
+			askVols[level] = nextPrice()
 		}
 
-		df.SetNames("market", "code", "date", "open", "low", "high", "close", "volume", "amount")
+		quote.Bid1, quote.Bid2, quote.Bid3, quote.Bid4, quote.Bid5 = bids[0], bids[1], bids[2], bids[3], bids[4]
+		quote.Ask1, quote.Ask2, quote.Ask3, quote.Ask4, quote.Ask5 = asks[0], asks[1], asks[2], asks[3], asks[4]
+		quote.BidVol1, quote.BidVol2, quote.BidVol3, quote.BidVol4, quote.BidVol5 = bidVols[0], bidVols[1], bidVols[2], bidVols[3], bidVols[4]
+		quote.AskVol1, quote.AskVol2, quote.AskVol3, quote.AskVol4, quote.AskVol5 = askVols[0], askVols[1], askVols[2], askVols[3], askVols[4]
+		if nil != err { return quoteList, err }
 
-		stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockDay, fileName)
-		client.historySaveFile(df, stocksPath)
-		return
+		if err = decoder.Skip(2); nil != err { return quoteList, err } // 未知
+		for unknownIdx := 0; unknownIdx < 4; unknownIdx++ { nextPrice() }
+		if nil != err { return quoteList, err }
+		if err = decoder.Skip(4); nil != err { return quoteList, err } // 涨速(2) + 活跃度(2)
+
+		quoteList = append(quoteList, quote)
 	}
 
-	df := client.onStockMinsHistory(market, strCode, int(stockLength), littleEndianBuffer)
-	if nil != df.Err {
-		//logger.Info("\t接收行情 %d%s 的数据出错, Err: %v", market, strCode, df.Err)
-		return
+	return quoteList, nil
+}
+
+/**
+ * 解析分笔成交数据, 历史分笔中没有成交笔数
+ */
+func decodeTransaction(rawData []byte, isHistory bool) ([]StockTickModel, error) {
+	var err error
+	var tickList []StockTickModel
+
+	decoder := comm.NewPriceDecoder(rawData)
+	nextPrice := func() int {
+		if nil != err { return 0 }
+		var value int
+		value, err = decoder.NextInt()
+		return value
+	}
+
+	tickCount, err := decoder.ReadUint16()
+	if nil != err { return nil, err }
+
+	if isHistory {
+		if err = decoder.Skip(4); nil != err { return nil, err } // 略过昨收价
 	}
 
-	df.SetNames("market", "code", "date", "time", "open", "low", "high", "close", "volume", "amount")
+	lastPrice := 0
+	for idx := 0; idx < int(tickCount); idx++ {
+		var tick StockTickModel
+
+		var minutes uint16
+		minutes, err = decoder.ReadUint16()
+		if nil != err { return tickList, err }
+		tick.Time = fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+
+		lastPrice += nextPrice()
+		tick.Price = float64(lastPrice)/100.0
+		tick.Volume = nextPrice()
+		if !isHistory { tick.Num = nextPrice() }
+		tick.Direction = nextPrice()
+		nextPrice()  // 未知
+		if nil != err { return tickList, err }
+
+		tickList = append(tickList, tick)
+	}
+
+	return tickList, nil
+}
+
+/**
+ * 分时数据中第idx个点对应的时间(上午09:31~11:30, 下午13:01~15:00)
+ */
+func minuteTimeShareTime(idx int) string {
+	minutes := 9*60 + 31 + idx
+	if idx >= 120 { minutes = 13*60 + 1 + idx - 120 }
+
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+/**
+ * 解析分时数据
+ */
+func decodeMinuteTimeShare(rawData []byte, isHistory bool) ([]MinuteTimeModel, error) {
+	var err error
+	var minuteList []MinuteTimeModel
+
+	decoder := comm.NewPriceDecoder(rawData)
+	nextPrice := func() int {
+		if nil != err { return 0 }
+		var value int
+		value, err = decoder.NextInt()
+		return value
+	}
+
+	minuteCount, err := decoder.ReadUint16()
+	if nil != err { return nil, err }
+
+	if isHistory {
+		err = decoder.Skip(4) // 略过昨收价
+	} else {
+		err = decoder.Skip(2) // 未知
+	}
+	if nil != err { return nil, err }
+
+	// 价格及均价都是与前一个点的差值
+	lastPrice := 0
+	lastAvgPrice := 0
+	for idx := 0; idx < int(minuteCount); idx++ {
+		var minute MinuteTimeModel
+
+		lastPrice += nextPrice()
+		lastAvgPrice += nextPrice()
+		minute.Volume = nextPrice()
+		if nil != err { return minuteList, err }
+
+		minute.Time = minuteTimeShareTime(idx)
+		minute.Price = float64(lastPrice)/100.0
+		minute.AvgPrice = float64(lastAvgPrice)/100.0
+
+		minuteList = append(minuteList, minute)
+	}
+
+	return minuteList, nil
+}
+
+/**
+ * 解析F10资料目录
+ */
+func decodeCompanyInfoCategory(rawData []byte) ([]CompanyInfoCategoryModel, error) {
+	var categoryItems pkg.CompanyInfoCategoryList
+	var categoryList []CompanyInfoCategoryModel
+
+	if _, err := pkg.Unmarshal(rawData, &categoryItems); nil != err { return nil, err }
+
+	for _, categoryItem := range categoryItems.Items {
+		categoryList = append(categoryList, CompanyInfoCategoryModel{categoryItem.Name, categoryItem.FileName,
+			int(categoryItem.Start), int(categoryItem.Length)})
+	}
+
+	return categoryList, nil
+}
+
+/**
+ * 解析F10资料内容
+ */
+func decodeCompanyInfoContent(rawData []byte) (string, error) {
+	littleEndianBuffer := gbytes.NewLittleEndianStream(rawData)
+
+	_, err := littleEndianBuffer.ReadBuff(10)                   // 略过市场、股票代码等信息
+	if nil != err { return "", err }
+	contentLength, err := littleEndianBuffer.ReadUint16() // 内容长度
+	if nil != err { return "", err }
+
+	rawContent, err := littleEndianBuffer.ReadBuff(int(contentLength))
+	if nil != err { return "", err }
+
+	return utils.ConvertTo(string(rawContent), "gbk", "utf8"), nil
+}
+
+/**
+ * 解析服务器文件的元信息
+ */
+func decodeFileMeta(rawData []byte) (pkg.FileMetaItem, error) {
+	var fileMeta pkg.FileMetaItem
+
+	_, err := pkg.Unmarshal(rawData, &fileMeta)
+	return fileMeta, err
+}
+
+/**
+ * 解析服务器文件的分块内容
+ */
+func decodeFileChunk(rawData []byte) ([]byte, error) {
+	littleEndianBuffer := gbytes.NewLittleEndianStream(rawData)
+
+	chunkSize, err := littleEndianBuffer.ReadUint32()  // 本块的长度
+	if nil != err || 0 >= chunkSize { return nil, err }
 
-	stocksPath := fmt.Sprintf("%s%s%s", client.Configure.GetApp().DataPath, client.Configure.GetTdx().Files.StockMin, fileName)
-	client.historySaveFile(df, stocksPath)
+	return littleEndianBuffer.ReadBuff(int(chunkSize))
 }
@@ -0,0 +1,157 @@
+package ctdx
+
+import (
+	"sync"
+	"time"
+	"context"
+	"sync/atomic"
+)
+
+/**
+ * 发送队列的统计数据
+ */
+type SendStats struct {
+	Waiting     int            // 正在等待发送的请求数
+	PeakWaiting int            // 等待发送的请求数的最大值
+	InFlight    int            // 已发送但尚未收到应答的请求数
+	Sent        int64          // 已发送的请求总数
+	Throttled   time.Duration  // 因限速及窗口已满而等待的总时间
+}
+
+/**
+ * 发送请求前的限速及发送窗口, 每个客户端一个, 每次连接时按 RateLimit 及 MaxInFlight 重新设置
+ */
+type requestLimiter struct {
+	lock        sync.Mutex
+	interval    time.Duration  // 两个请求之间的最短间隔, 0为不限速
+	maxInFlight int            // 发送窗口的大小, 0为不限制
+	inFlight    int            // 已占用的发送窗口
+	freed       chan struct{}  // 释放发送窗口或修改设置时关闭, 唤醒等待的请求
+	next        time.Time      // 下一个请求最早的发送时间
+
+	waiting     int64
+	peakWaiting int64
+	sent        int64
+	throttled   int64
+}
+
+/**
+ * rate 为每秒最多发送的请求数, maxInFlight 为同时等待应答的请求数上限, 为0时不限制
+ */
+func newRequestLimiter(rate float64, maxInFlight int) *requestLimiter {
+	limiter := &requestLimiter{freed: make(chan struct{})}
+	limiter.configure(rate, maxInFlight)
+	return limiter
+}
+
+/**
+ * 修改限速及发送窗口的大小, 已占用的发送窗口保持不变
+ */
+func (l *requestLimiter) configure(rate float64, maxInFlight int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.interval = 0
+	if 0 < rate { l.interval = time.Duration(float64(time.Second) / rate) }
+	l.maxInFlight = maxInFlight
+	l.notify()
+}
+
+/**
+ * 唤醒等待发送窗口的请求, 调用时需持有锁
+ */
+func (l *requestLimiter) notify() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+/**
+ * 等待并占用发送窗口
+ */
+func (l *requestLimiter) acquireSlot(ctx context.Context) error {
+	for {
+		l.lock.Lock()
+		if 0 >= l.maxInFlight || l.inFlight < l.maxInFlight {
+			l.inFlight++
+			l.lock.Unlock()
+			return nil
+		}
+		freed := l.freed
+		l.lock.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return contextError(ctx.Err())
+		}
+	}
+}
+
+/**
+ * 等待发送窗口及限速, 成功返回后必须在请求完成时调用 release
+ */
+func (l *requestLimiter) acquire(ctx context.Context) error {
+	start := time.Now()
+	waiting := atomic.AddInt64(&l.waiting, 1)
+	defer func() {
+		atomic.AddInt64(&l.waiting, -1)
+		atomic.AddInt64(&l.throttled, int64(time.Since(start)))
+	}()
+
+	for peak := atomic.LoadInt64(&l.peakWaiting); waiting > peak; peak = atomic.LoadInt64(&l.peakWaiting) {
+		if atomic.CompareAndSwapInt64(&l.peakWaiting, peak, waiting) { break }
+	}
+
+	if err := l.acquireSlot(ctx); nil != err { return err }
+
+	var delay time.Duration
+	l.lock.Lock()
+	if 0 < l.interval {
+		now := time.Now()
+		if l.next.Before(now) { l.next = now }
+		delay = l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+	}
+	l.lock.Unlock()
+
+	if 0 < delay {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			l.release()
+			return contextError(ctx.Err())
+		}
+	}
+
+	atomic.AddInt64(&l.sent, 1)
+	return nil
+}
+
+/**
+ * 请求收到应答或被取消, 释放发送窗口
+ */
+func (l *requestLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if 0 >= l.inFlight { return }
+	l.inFlight--
+	l.notify()
+}
+
+func (l *requestLimiter) stats() SendStats {
+	return SendStats{Waiting: int(atomic.LoadInt64(&l.waiting)), PeakWaiting: int(atomic.LoadInt64(&l.peakWaiting)),
+		Sent: atomic.LoadInt64(&l.sent), Throttled: time.Duration(atomic.LoadInt64(&l.throttled))}
+}
+
+/**
+ * 发送队列的统计数据, 未连接过时全部为0
+ */
+func (client *TdxClient) SendStats() SendStats {
+	var stats SendStats
+	if nil != client.limiter { stats = client.limiter.stats() }
+	if nil != client.dispatcher { stats.InFlight = client.dispatcher.Pending() }
+	return stats
+}
@@ -0,0 +1,101 @@
+package ctdx
+
+import (
+	"sync"
+	"time"
+	"context"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/ctdx/tdxtest"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试按每秒请求数限速", t, func() {
+		limiter := newRequestLimiter(100, 0)
+
+		start := time.Now()
+		for idx := 0; idx < 5; idx++ {
+			So(limiter.acquire(ctx), ShouldBeNil)
+		}
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+		So(limiter.stats().Sent, ShouldEqual, 5)
+	})
+
+	Convey("测试发送窗口已满时等待", t, func() {
+		limiter := newRequestLimiter(0, 2)
+		So(limiter.acquire(ctx), ShouldBeNil)
+		So(limiter.acquire(ctx), ShouldBeNil)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		So(limiter.acquire(timeoutCtx), ShouldEqual, ErrRequestTimeout)
+
+		limiter.release()
+		So(limiter.acquire(ctx), ShouldBeNil)
+
+		stats := limiter.stats()
+		So(stats.Waiting, ShouldEqual, 0)
+		So(stats.PeakWaiting, ShouldEqual, 1)
+		So(stats.Throttled, ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+	})
+
+	Convey("测试修改发送窗口的大小", t, func() {
+		limiter := newRequestLimiter(0, 1)
+		So(limiter.acquire(ctx), ShouldBeNil)
+
+		acquired := make(chan error, 1)
+		go func() { acquired <- limiter.acquire(ctx) }()
+
+		// 修改设置时唤醒等待的请求, 已占用的发送窗口保持不变
+		limiter.configure(0, 2)
+		var err error
+		select {
+		case err = <-acquired:
+		case <-time.After(time.Second):
+			err = ErrRequestTimeout
+		}
+		So(err, ShouldBeNil)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		So(limiter.acquire(timeoutCtx), ShouldEqual, ErrRequestTimeout)
+
+		limiter.configure(0, 0)
+		So(limiter.acquire(ctx), ShouldBeNil)
+	})
+
+	Convey("测试限制同时等待应答的请求数", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.MaxInFlight = 1
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		server.SetDelay(20 * time.Millisecond)
+		defer server.SetDelay(0)
+
+		var wg sync.WaitGroup
+		errList := make([]error, 4)
+		for idx := range errList {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				_, errList[idx] = tdxClient.GetStockList(ctx, 1)
+			}(idx)
+		}
+		wg.Wait()
+
+		for _, err := range errList { So(err, ShouldBeNil) }
+
+		stats := tdxClient.SendStats()
+		So(stats.InFlight, ShouldEqual, 0)
+		So(stats.Waiting, ShouldEqual, 0)
+		So(stats.PeakWaiting, ShouldBeGreaterThan, 1)
+	})
+}
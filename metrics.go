@@ -0,0 +1,229 @@
+package ctdx
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"expvar"
+	"strconv"
+	"net/http"
+
+	"github.com/datochan/gcom/utils"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+// 应答延迟直方图的各个区间的上限, 超过最后一个区间的计入 +Inf
+var latencyBuckets = []time.Duration{10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 5 * time.Second}
+
+/**
+ * 应答延迟的直方图, 实现 expvar.Var
+ */
+type latencyHistogram struct {
+	lock   sync.Mutex
+	counts []int64         // 各个区间的数量, 最后一个为 +Inf
+	count  int64
+	total  time.Duration
+	max    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	idx := 0
+	for idx < len(latencyBuckets) && latency > latencyBuckets[idx] { idx++ }
+	h.counts[idx]++
+	h.count++
+	h.total += latency
+	if latency > h.max { h.max = latency }
+}
+
+func (h *latencyHistogram) String() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var avg time.Duration
+	if 0 < h.count { avg = h.total / time.Duration(h.count) }
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `{"count": %d, "avg_ms": %.3f, "max_ms": %.3f, "buckets": {`, h.count,
+		avg.Seconds()*1000, h.max.Seconds()*1000)
+	for idx, count := range h.counts {
+		bound := "+Inf"
+		if idx < len(latencyBuckets) { bound = latencyBuckets[idx].String() }
+		if 0 < idx { buffer.WriteString(", ") }
+		fmt.Fprintf(&buffer, "%s: %d", strconv.Quote(bound), count)
+	}
+	buffer.WriteString("}}")
+	return buffer.String()
+}
+
+/**
+ * 连接的运行指标, 兼容 expvar, 可以通过 Publish 发布到 /debug/vars, 或作为 http.Handler 单独提供
+ * 计数在重连后继续累加
+ */
+type Metrics struct {
+	vars        *expvar.Map
+
+	requests    *expvar.Map  // CmdId => 发送的请求数
+	responses   *expvar.Map  // EventId => 收到的应答数
+	latency     *expvar.Map  // CmdId => 应答延迟的直方图
+	bytesOut    *expvar.Int  // 发送的字节数(含包头)
+	bytesIn     *expvar.Int  // 收到的字节数(含包头, 压缩后)
+	bodyIn      *expvar.Int  // 收到的包体字节数(压缩后)
+	rawBodyIn   *expvar.Int  // 收到的包体字节数(解压后)
+	decodeErrors   *expvar.Int  // 解析应答失败的次数
+	unknownPackets *expvar.Int  // 没有对应请求也没有处理过程的应答数
+	reconnects  *expvar.Int  // 自动重连成功的次数
+	disconnects *expvar.Int  // 连接意外断开的次数
+
+	lock        sync.Mutex
+	recorder    *pkg.LatencyRecorder  // 匹配请求及应答, 延迟交给 observeLatency 生成直方图
+}
+
+func newMetrics(client *TdxClient) *Metrics {
+	metrics := &Metrics{vars: new(expvar.Map).Init(), requests: new(expvar.Map).Init(),
+		responses: new(expvar.Map).Init(), latency: new(expvar.Map).Init(), bytesOut: new(expvar.Int),
+		bytesIn: new(expvar.Int), bodyIn: new(expvar.Int), rawBodyIn: new(expvar.Int),
+		decodeErrors: new(expvar.Int), unknownPackets: new(expvar.Int), reconnects: new(expvar.Int),
+		disconnects: new(expvar.Int)}
+	metrics.recorder = pkg.NewLatencyObserver(metrics.observeLatency)
+
+	metrics.vars.Set("state", expvar.Func(func() interface{} { return client.State().String() }))
+	metrics.vars.Set("host", expvar.Func(func() interface{} { return client.CurrentHost() }))
+	metrics.vars.Set("requests", metrics.requests)
+	metrics.vars.Set("responses", metrics.responses)
+	metrics.vars.Set("latency", metrics.latency)
+	metrics.vars.Set("bytes_out", metrics.bytesOut)
+	metrics.vars.Set("bytes_in", metrics.bytesIn)
+	metrics.vars.Set("body_bytes_in", metrics.bodyIn)
+	metrics.vars.Set("raw_body_bytes_in", metrics.rawBodyIn)
+	metrics.vars.Set("compress_ratio", expvar.Func(metrics.compressRatio))
+	metrics.vars.Set("decode_errors", metrics.decodeErrors)
+	metrics.vars.Set("unknown_packets", metrics.unknownPackets)
+	metrics.vars.Set("reconnects", metrics.reconnects)
+	metrics.vars.Set("disconnects", metrics.disconnects)
+	metrics.vars.Set("pending", expvar.Func(func() interface{} { return client.SendStats().InFlight }))
+	metrics.vars.Set("send", expvar.Func(func() interface{} { return client.SendStats() }))
+
+	return metrics
+}
+
+/**
+ * 压缩后与解压后的包体长度之比, 没有收到数据时为0
+ */
+func (m *Metrics) compressRatio() interface{} {
+	rawBodyIn := m.rawBodyIn.Value()
+	if 0 >= rawBodyIn { return 0.0 }
+	return float64(m.bodyIn.Value()) / float64(rawBodyIn)
+}
+
+/**
+ * 全部指标的 JSON, 实现 expvar.Var
+ */
+func (m *Metrics) String() string {
+	return m.vars.String()
+}
+
+/**
+ * 以 name 发布到 expvar(/debug/vars), 同一个名称只能发布一次
+ */
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, m)
+}
+
+/**
+ * 以 JSON 格式输出全部指标
+ */
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintln(w, m.String())
+}
+
+/**
+ * 按名称取出单个指标, 如 requests, bytes_in, 不存在时返回nil
+ */
+func (m *Metrics) Get(key string) expvar.Var {
+	return m.vars.Get(key)
+}
+
+func cmdKey(id uint16) string {
+	return fmt.Sprintf("0x%04X", id)
+}
+
+/**
+ * 统计收发封包的拦截器, recv 放在拦截器链的最前面以统计所有收到的封包,
+ * send 放在最后面, 不统计被其它拦截器放弃的请求
+ */
+func (m *Metrics) interceptors() (recv pkg.Interceptor, send pkg.Interceptor) {
+	headerSize := int64(utils.SizeStruct(pkg.ResponseHeader{}))
+	latency := m.recorder.Interceptor()
+
+	recv.Recv = func(respNode *pkg.ResponseNode) error {
+		m.responses.Add(cmdKey(respNode.EventId), 1)
+		m.bytesIn.Add(headerSize + int64(respNode.BodyLength))
+		m.bodyIn.Add(int64(respNode.BodyLength))
+		m.rawBodyIn.Add(int64(respNode.BodyMaxLength))
+		return latency.Recv(respNode)
+	}
+
+	send.Send = func(reqNode *pkg.RequestNode) error {
+		rawData, _ := reqNode.RawData.([]byte)
+		m.requests.Add(cmdKey(reqNode.CmdId), 1)
+		m.bytesOut.Add(int64(pkg.RequestHeaderSize + len(rawData)))
+		return latency.Send(reqNode)
+	}
+
+	return recv, send
+}
+
+/**
+ * 建立新的连接时清除尚未收到应答的请求
+ */
+func (m *Metrics) connected() {
+	if nil != m { m.recorder.Reset() }
+}
+
+func (m *Metrics) observeLatency(cmdId uint16, latency time.Duration) {
+	key := cmdKey(cmdId)
+
+	m.lock.Lock()
+	histogram, _ := m.latency.Get(key).(*latencyHistogram)
+	if nil == histogram {
+		histogram = newLatencyHistogram()
+		m.latency.Set(key, histogram)
+	}
+	m.lock.Unlock()
+
+	histogram.observe(latency)
+}
+
+func (m *Metrics) decodeFailed() {
+	if nil != m { m.decodeErrors.Add(1) }
+}
+
+func (m *Metrics) unknownPacket() {
+	if nil != m { m.unknownPackets.Add(1) }
+}
+
+func (m *Metrics) reconnected() {
+	if nil != m { m.reconnects.Add(1) }
+}
+
+func (m *Metrics) disconnected() {
+	if nil != m { m.disconnects.Add(1) }
+}
+
+/**
+ * 连接的运行指标
+ */
+func (client *TdxClient) Metrics() *Metrics {
+	return client.metrics
+}
@@ -0,0 +1,87 @@
+package ctdx
+
+import (
+	"time"
+	"context"
+	"testing"
+	"encoding/json"
+	"net/http/httptest"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/ctdx/tdxtest"
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试统计收发的封包", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		_, err = tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+
+		// 无法解析的权息数据
+		bonusEventId := pkg.GenerateStockBonus(nil, 0).EventId
+		server.Handle(bonusEventId, func(req tdxtest.Request) ([]byte, error) { return []byte{0x01, 0x00, 0x05}, nil })
+		_, err = tdxClient.GetBonus(ctx, []string{"0000001"})
+		So(err, ShouldNotBeNil)
+
+		// 没有对应请求的应答
+		unknownNode := pkg.ResponseNode{ResponseHeader: pkg.ResponseHeader{Index: 0x10, EventId: 0x1234}, RawData: []byte{}}
+		tdxClient.dispatcher.HandleProc(nil, unknownNode)
+
+		recorder := httptest.NewRecorder()
+		tdxClient.Metrics().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		var result struct {
+			State          string
+			Requests       map[string]int64
+			Responses      map[string]int64
+			Latency        map[string]struct{ Count int64 }
+			BytesOut       int64   `json:"bytes_out"`
+			BytesIn        int64   `json:"bytes_in"`
+			CompressRatio  float64 `json:"compress_ratio"`
+			DecodeErrors   int64   `json:"decode_errors"`
+			UnknownPackets int64   `json:"unknown_packets"`
+			Pending        int
+		}
+		So(json.Unmarshal(recorder.Body.Bytes(), &result), ShouldBeNil)
+
+		barsNode := pkg.GenerateStockDayItem(0, "", 0, 0, 0)
+		So(result.State, ShouldEqual, "Ready")
+		So(result.Requests[cmdKey(barsNode.CmdId)], ShouldEqual, 1)
+		So(result.Responses[cmdKey(barsNode.EventId)], ShouldEqual, 1)
+		So(result.Latency[cmdKey(barsNode.CmdId)].Count, ShouldEqual, 1)
+		So(result.BytesOut, ShouldBeGreaterThan, 0)
+		So(result.BytesIn, ShouldBeGreaterThan, 0)
+		So(result.CompressRatio, ShouldBeGreaterThan, 0)
+		So(result.DecodeErrors, ShouldEqual, 1)
+		So(result.UnknownPackets, ShouldEqual, 1)
+		So(result.Pending, ShouldEqual, 0)
+	})
+
+	Convey("测试统计自动重连", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient(server.Addr())
+		tdxClient.Reconnect = &ReconnectPolicy{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+
+		server.InjectFault(pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId, tdxtest.FaultDisconnect)
+		_, err = tdxClient.GetDayBars(ctx, 1, "600000", 20180102, 20180105)
+		So(err, ShouldBeNil)
+
+		So(tdxClient.Metrics().Get("reconnects").String(), ShouldEqual, "1")
+		So(tdxClient.Metrics().Get("disconnects").String(), ShouldEqual, "1")
+	})
+}
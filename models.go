@@ -50,6 +50,21 @@ type StockDayModel struct {
     Amount     float64
 }
 
+// 指数日线数据的文件结构
+type IndexDayModel struct {
+	Market     int
+	Code       string
+    Date	   int
+	Open       float64
+	Low        float64
+	High       float64
+    Close      float64
+	Volume     int
+    Amount     float64
+    Up         int     // 上涨家数
+    Down       int     // 下跌家数
+}
+
 // 五分钟线数据的文件结构
 type StockMinsModel struct {
 	Market     int
@@ -63,3 +78,80 @@ type StockMinsModel struct {
 	Volume     int
     Amount     float64
 }
+
+// 实时行情快照
+type QuoteModel struct {
+	Market     int
+	Code       string
+	ServerTime string  // 服务器时间 hh:mm:ss.sss
+	Price      float64 // 现价
+	PreClose   float64 // 昨收
+	Open       float64
+	High       float64
+	Low        float64
+	Volume     int     // 总量(手)
+	CurVolume  int     // 现量(手)
+	Amount     float64 // 成交额
+	SellVolume int     // 内盘
+	BuyVolume  int     // 外盘
+	Bid1       float64
+	Ask1       float64
+	BidVol1    int
+	AskVol1    int
+	Bid2       float64
+	Ask2       float64
+	BidVol2    int
+	AskVol2    int
+	Bid3       float64
+	Ask3       float64
+	BidVol3    int
+	AskVol3    int
+	Bid4       float64
+	Ask4       float64
+	BidVol4    int
+	AskVol4    int
+	Bid5       float64
+	Ask5       float64
+	BidVol5    int
+	AskVol5    int
+}
+
+// 分笔成交数据
+type StockTickModel struct {
+	Market     int
+	Code       string
+	Date       int
+	Time       string  // hh:mm
+	Price      float64
+	Volume     int     // 成交量(手)
+	Num        int     // 成交笔数(历史分笔中没有此数据)
+	Direction  int     // 0: 买盘; 1: 卖盘; 2: 中性盘
+}
+
+// 分时数据(每天240个点)
+type MinuteTimeModel struct {
+	Market     int
+	Code       string
+	Date       int
+	Time       string  // hh:mm
+	Price      float64
+	AvgPrice   float64 // 均价(服务器计算的当日成交均价)
+	Volume     int     // 成交量(手)
+}
+
+// F10资料目录
+type CompanyInfoCategoryModel struct {
+	Name       string  // 目录名称
+	FileName   string  // 所在文件名
+	Start      int     // 在文件中的起始位置
+	Length     int     // 内容长度
+}
+
+// 回放封包记录时解析出的数据
+type ReplayResult struct {
+	StockList []StockBaseModel   // 股票列表
+	Bonus     []StockBonusModel  // 权息数据
+	DayBars   []StockDayModel    // 日线及以上周期的K线
+	IndexBars []IndexDayModel    // 指数日线及以上周期的K线
+	MinsBars  []StockMinsModel   // 分钟线
+}
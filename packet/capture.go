@@ -0,0 +1,202 @@
+package packet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"encoding/binary"
+
+	"github.com/datochan/gcom/cnet"
+	"github.com/datochan/gcom/logger"
+)
+
+const (
+	captureMagic   = "TDXCAP02"   // 封包记录文件的文件头, 应答记录中带有解压前的包体
+	captureMagicV1 = "TDXCAP01"   // 旧的记录文件, 应答记录中只有解压后的包体
+
+	CaptureRequest  byte = 0x00 // 发送的请求封包
+	CaptureResponse byte = 0x01 // 收到的应答封包
+)
+
+// 封包记录中的一条记录
+type CaptureRecord struct {
+	Direction byte          // CaptureRequest 或 CaptureResponse
+	Time      time.Time     // 收发时间
+	Request   RequestNode   // Direction为CaptureRequest时有效, RawData为[]byte
+	Response  ResponseNode  // Direction为CaptureResponse时有效, RawData为解压后的[]byte, 封包被压缩时 CompressedBody 为解压前的包体
+}
+
+// 请求封包在记录文件中的包头
+type captureRequestHeader struct {
+	EventId uint16
+	CmdId   uint16
+	IsRaw   byte
+	Index   uint16
+}
+
+// 记录文件关闭后仍在收发的封包不再记录
+var ErrCaptureClosed = errors.New("封包记录文件已关闭")
+
+/**
+ * 将收发的封包写入记录文件
+ */
+type CaptureWriter struct {
+	mutex  sync.Mutex  // 保护 writer 及 closed, 写入与关闭互斥
+	writer io.Writer
+	closed bool
+}
+
+func NewCaptureWriter(writer io.Writer) (*CaptureWriter, error) {
+	if _, err := writer.Write([]byte(captureMagic)); nil != err { return nil, err }
+	return &CaptureWriter{writer: writer}, nil
+}
+
+func (w *CaptureWriter) writeRecord(direction byte, header interface{}, bodyList ...[]byte) error {
+	var newBuffer bytes.Buffer
+
+	newBuffer.WriteByte(direction)
+	binary.Write(&newBuffer, binary.LittleEndian, time.Now().UnixNano())
+	binary.Write(&newBuffer, binary.LittleEndian, header)
+	for _, body := range bodyList {
+		binary.Write(&newBuffer, binary.LittleEndian, uint32(len(body)))
+		newBuffer.Write(body)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed { return ErrCaptureClosed }
+	_, err := w.writer.Write(newBuffer.Bytes())
+	return err
+}
+
+/**
+ * 关闭记录, writer 实现了 io.Closer 时一并关闭
+ * 会等待正在进行的写入完成, 之后的写入返回 ErrCaptureClosed
+ */
+func (w *CaptureWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed { return nil }
+	w.closed = true
+	if closer, ok := w.writer.(io.Closer); ok { return closer.Close() }
+	return nil
+}
+
+/**
+ * 记录发送的请求封包
+ */
+func (w *CaptureWriter) WriteRequest(reqNode RequestNode) error {
+	body, _ := reqNode.RawData.([]byte)
+	return w.writeRecord(CaptureRequest,
+		captureRequestHeader{reqNode.EventId, reqNode.CmdId, reqNode.IsRaw, reqNode.Index}, body)
+}
+
+/**
+ * 记录收到的应答封包, 包括解压后的包体及解压前的包体(协议设置了 KeepCompressed 时)
+ */
+func (w *CaptureWriter) WriteResponse(respNode ResponseNode) error {
+	body, _ := respNode.RawData.([]byte)
+	return w.writeRecord(CaptureResponse, respNode.ResponseHeader, body, respNode.CompressedBody)
+}
+
+/**
+ * 读取长度及内容
+ */
+func readCaptureBody(reader io.Reader) ([]byte, error) {
+	var bodyLength uint32
+	if err := binary.Read(reader, binary.LittleEndian, &bodyLength); nil != err { return nil, err }
+
+	body := make([]byte, bodyLength)
+	if _, err := io.ReadFull(reader, body); nil != err { return nil, err }
+	return body, nil
+}
+
+/**
+ * 读取记录文件中的所有记录
+ */
+func ReadCapture(reader io.Reader) ([]CaptureRecord, error) {
+	var recordList []CaptureRecord
+
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(reader, magic); nil != err || (captureMagic != string(magic) && captureMagicV1 != string(magic)) {
+		return nil, errors.New("无效的封包记录文件")
+	}
+	hasCompressed := captureMagic == string(magic)
+
+	for {
+		var record CaptureRecord
+		var timestamp int64
+
+		direction := make([]byte, 1)
+		if _, err := io.ReadFull(reader, direction); nil != err {
+			if io.EOF == err { return recordList, nil }
+			return recordList, err
+		}
+		record.Direction = direction[0]
+
+		if err := binary.Read(reader, binary.LittleEndian, &timestamp); nil != err { return recordList, err }
+		record.Time = time.Unix(0, timestamp)
+
+		var err error
+		var reqHeader captureRequestHeader
+		switch record.Direction {
+		case CaptureRequest: err = binary.Read(reader, binary.LittleEndian, &reqHeader)
+		case CaptureResponse: err = binary.Read(reader, binary.LittleEndian, &record.Response.ResponseHeader)
+		default: err = errors.New("无效的封包记录类型")
+		}
+		if nil != err { return recordList, err }
+
+		body, err := readCaptureBody(reader)
+		if nil != err { return recordList, err }
+
+		if CaptureRequest == record.Direction {
+			record.Request = RequestNode{reqHeader.EventId, reqHeader.CmdId, reqHeader.IsRaw, reqHeader.Index, body}
+		} else {
+			record.Response.RawData = body
+			if hasCompressed {
+				compressedBody, err := readCaptureBody(reader)
+				if nil != err { return recordList, err }
+				if 0 < len(compressedBody) { record.Response.CompressedBody = compressedBody }
+			}
+		}
+
+		recordList = append(recordList, record)
+	}
+}
+
+/**
+ * 记录所有收发封包的协议包装
+ * 请求在组包时记录, 即经过 InterceptSend 处理后实际发送的请求, 被拦截器放弃的请求不会记录
+ */
+type RecordingProtocol struct {
+	*TdxPacketProtocolImpl
+	capture *CaptureWriter
+}
+
+func NewRecordingProtocol(protocol *TdxPacketProtocolImpl, capture *CaptureWriter) *RecordingProtocol {
+	return &RecordingProtocol{protocol, capture}
+}
+
+func (rp *RecordingProtocol) ReadPacket(s cnet.ISession) (interface{}, error) {
+	packet, err := rp.TdxPacketProtocolImpl.ReadPacket(s)
+	if respNode, ok := packet.(ResponseNode); ok {
+		if err := rp.capture.WriteResponse(respNode); nil != err && ErrCaptureClosed != err { logger.Error("记录应答封包失败: %v", err) }
+	}
+	return packet, err
+}
+
+func (rp *RecordingProtocol) BuildPacket(pkgNode interface{}) []byte {
+	if reqNode, ok := pkgNode.(RequestNode); ok {
+		if err := rp.capture.WriteRequest(reqNode); nil != err && ErrCaptureClosed != err { logger.Error("记录请求封包失败: %v", err) }
+	}
+	return rp.TdxPacketProtocolImpl.BuildPacket(pkgNode)
+}
+
+func (rp *RecordingProtocol) SendPacket(conn net.Conn, buff []byte) error {
+	return rp.TdxPacketProtocolImpl.SendPacket(conn, buff)
+}
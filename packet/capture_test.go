@@ -0,0 +1,80 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapture(t *testing.T) {
+	var captureBuffer bytes.Buffer
+
+	reqNode := GenerateMarketStockCount(1)
+	respNode := ResponseNode{ResponseHeader{PacketFlag: 0x0074CBB1, IsCompress: 0x0C, Index: 3,
+		CmdId: 0x6C, EventId: 0x044E, BodyLength: 2, BodyMaxLength: 2}, []byte{0x02, 0x00}, nil}
+
+	Convey("测试封包记录的写入与读取", t, func() {
+		capture, err := NewCaptureWriter(&captureBuffer)
+		So(err, ShouldBeNil)
+		So(capture.WriteRequest(reqNode), ShouldBeNil)
+		So(capture.WriteResponse(respNode), ShouldBeNil)
+
+		recordList, err := ReadCapture(bytes.NewReader(captureBuffer.Bytes()))
+		So(err, ShouldBeNil)
+		So(recordList, ShouldHaveLength, 2)
+		So(recordList[0].Direction, ShouldEqual, CaptureRequest)
+		So(recordList[0].Request, ShouldResemble, reqNode)
+		So(recordList[1].Direction, ShouldEqual, CaptureResponse)
+		So(recordList[1].Response, ShouldResemble, respNode)
+
+		Convey("测试记录解压前的包体", func() {
+			var compressedBuffer bytes.Buffer
+			compressedNode := respNode
+			compressedNode.IsCompress = 0x1C
+			compressedNode.CompressedBody = []byte{0x78, 0x9C, 0x63, 0x62, 0x00, 0x00}
+
+			capture, err := NewCaptureWriter(&compressedBuffer)
+			So(err, ShouldBeNil)
+			So(capture.WriteResponse(compressedNode), ShouldBeNil)
+
+			recordList, err := ReadCapture(bytes.NewReader(compressedBuffer.Bytes()))
+			So(err, ShouldBeNil)
+			So(recordList, ShouldHaveLength, 1)
+			So(recordList[0].Response, ShouldResemble, compressedNode)
+		})
+
+		Convey("测试读取旧格式的记录文件", func() {
+			// 旧格式的应答记录中没有解压前的包体
+			var oldBuffer bytes.Buffer
+			oldBuffer.WriteString("TDXCAP01")
+			oldBuffer.WriteByte(CaptureResponse)
+			binary.Write(&oldBuffer, binary.LittleEndian, int64(0))
+			binary.Write(&oldBuffer, binary.LittleEndian, respNode.ResponseHeader)
+			binary.Write(&oldBuffer, binary.LittleEndian, uint32(2))
+			oldBuffer.Write([]byte{0x02, 0x00})
+
+			recordList, err := ReadCapture(bytes.NewReader(oldBuffer.Bytes()))
+			So(err, ShouldBeNil)
+			So(recordList, ShouldHaveLength, 1)
+			So(recordList[0].Response, ShouldResemble, respNode)
+		})
+
+		Convey("测试被截断的记录文件", func() {
+			_, err := ReadCapture(bytes.NewReader(captureBuffer.Bytes()[:captureBuffer.Len()-1]))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("测试关闭后不再记录", func() {
+			var closedBuffer bytes.Buffer
+			capture, err := NewCaptureWriter(&closedBuffer)
+			So(err, ShouldBeNil)
+			So(capture.Close(), ShouldBeNil)
+			So(capture.Close(), ShouldBeNil)
+
+			So(capture.WriteRequest(reqNode), ShouldEqual, ErrCaptureClosed)
+			So(capture.WriteResponse(respNode), ShouldEqual, ErrCaptureClosed)
+			So(closedBuffer.String(), ShouldEqual, captureMagic)
+		})
+	})
+}
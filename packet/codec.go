@@ -0,0 +1,391 @@
+package packet
+
+import (
+	"fmt"
+	"math"
+	"bytes"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"encoding/binary"
+
+	gbytes "github.com/datochan/gcom/bytes"
+	"github.com/datochan/gcom/utils"
+
+	"github.com/datochan/ctdx/comm"
+)
+
+/**
+ * 基于结构体标签的封包编解码, 字段按声明顺序以小端字节序编解码:
+ *   整数、浮点数及其定长数组        按类型的大小编解码, 不需要标签
+ *   string `tdx:"gbk,N"`           N 字节的定长GBK字符串, 解码时去掉结尾的0并转换为UTF-8
+ *   整数/浮点数 `tdx:"price"`       通达信的变长价格编码(见 comm.BufferToDouble)
+ *   切片 `tdx:"count=uint16"`       重复的数据组, 前面是 uint8/uint16/uint32 类型的组数
+ *   切片 `tdx:"size=uint32"`        重复的数据组, 前面是 uint8/uint16/uint32 类型的数据总字节数,
+ *                                  结尾不完整的一组数据被丢弃(与按结构大小整除计算组数一致)
+ *   `tdx:"-"`                      忽略该字段
+ * 新的封包类型只需声明对应的结构体即可通过 Unmarshal/Marshal 编解码
+ */
+
+// 封包数据不完整
+var ErrCodecShort = errors.New("封包数据不完整")
+
+type codecTag struct {
+	kind   string        // 空, gbk, price, count, size, -
+	length int           // gbk 字符串的字节数
+	prefix reflect.Kind  // count/size 前缀的类型
+}
+
+func parseCodecTag(tag string) (codecTag, error) {
+	var result codecTag
+	if 0 == len(tag) { return result, nil }
+
+	parts := strings.Split(tag, ",")
+	switch {
+	case "-" == parts[0], "price" == parts[0]:
+		result.kind = parts[0]
+	case "gbk" == parts[0]:
+		if 2 != len(parts) { return result, fmt.Errorf("gbk 标签缺少长度: %s", tag) }
+		length, err := strconv.Atoi(parts[1])
+		if nil != err || 0 >= length { return result, fmt.Errorf("无效的字符串长度: %s", tag) }
+		result.kind, result.length = "gbk", length
+	case strings.HasPrefix(parts[0], "count="), strings.HasPrefix(parts[0], "size="):
+		kv := strings.SplitN(parts[0], "=", 2)
+		result.kind = kv[0]
+		switch kv[1] {
+		case "uint8": result.prefix = reflect.Uint8
+		case "uint16": result.prefix = reflect.Uint16
+		case "uint32": result.prefix = reflect.Uint32
+		default: return result, fmt.Errorf("无效的前缀类型: %s", tag)
+		}
+	default:
+		return result, fmt.Errorf("无效的标签: %s", tag)
+	}
+
+	return result, nil
+}
+
+/**
+ * 解码后单个字段在封包数据中的位置, 用于分析未知的封包
+ */
+type FieldOverlay struct {
+	Name   string       // 字段路径, 如 Items[0].Date, 数据组的前缀为 Items.count 或 Items.size
+	Offset int          // 在封包数据中的偏移
+	Length int          // 占用的字节数
+	Value  interface{}  // 解码后的值
+}
+
+type codecDecoder struct {
+	buffer []byte
+	pos    int
+	base   int              // buffer 在整个封包数据中的偏移
+	path   string           // 正在解码的字段路径
+	overlay *[]FieldOverlay // 不为空时记录每个字段的位置
+}
+
+func (d *codecDecoder) record(name string, start int, value interface{}) {
+	if nil == d.overlay { return }
+	*d.overlay = append(*d.overlay, FieldOverlay{name, d.base+start, d.pos-start, value})
+}
+
+func (d *codecDecoder) next(size int) ([]byte, error) {
+	if size > len(d.buffer)-d.pos { return nil, ErrCodecShort }
+	result := d.buffer[d.pos:d.pos+size]
+	d.pos += size
+	return result, nil
+}
+
+func (d *codecDecoder) readUint(kind reflect.Kind) (uint64, error) {
+	switch kind {
+	case reflect.Uint8, reflect.Int8, reflect.Bool:
+		raw, err := d.next(1)
+		if nil != err { return 0, err }
+		return uint64(raw[0]), nil
+	case reflect.Uint16, reflect.Int16:
+		raw, err := d.next(2)
+		if nil != err { return 0, err }
+		return uint64(binary.LittleEndian.Uint16(raw)), nil
+	case reflect.Uint32, reflect.Int32, reflect.Float32:
+		raw, err := d.next(4)
+		if nil != err { return 0, err }
+		return uint64(binary.LittleEndian.Uint32(raw)), nil
+	}
+
+	raw, err := d.next(8)
+	if nil != err { return 0, err }
+	return binary.LittleEndian.Uint64(raw), nil
+}
+
+func (d *codecDecoder) readPrice() (float64, error) {
+	decoder := comm.NewPriceDecoder(d.buffer[d.pos:])
+	value, err := decoder.Next()
+	if nil != err { return 0, ErrCodecShort }
+
+	d.pos += decoder.Pos()
+	return value, nil
+}
+
+/**
+ * 解码单个值, 记录字段位置时只记录不再包含其它字段的值
+ */
+func (d *codecDecoder) decode(v reflect.Value, tag codecTag) error {
+	start := d.pos
+	if err := d.decodeValue(v, tag); nil != err { return err }
+	if nil == d.overlay { return nil }
+
+	// 数据组及结构体由其中的字段分别记录
+	if "count" == tag.kind || "size" == tag.kind || reflect.Struct == v.Kind() { return nil }
+	if reflect.Array == v.Kind() && reflect.Uint8 != v.Type().Elem().Kind() { return nil }
+
+	d.record(d.path, start, v.Interface())
+	return nil
+}
+
+/**
+ * 子字段的路径, 只在记录字段位置时生成
+ */
+func (d *codecDecoder) childPath(parent string, name string, idx int) string {
+	if nil == d.overlay { return parent }
+	if 0 == len(name) { return fmt.Sprintf("%s[%d]", parent, idx) }
+	if 0 == len(parent) { return name }
+	return parent + "." + name
+}
+
+func (d *codecDecoder) decodeValue(v reflect.Value, tag codecTag) error {
+	switch tag.kind {
+	case "gbk":
+		raw, err := d.next(tag.length)
+		if nil != err { return err }
+		v.SetString(utils.ConvertTo(gbytes.BytesToString(raw), "gbk", "utf8"))
+		return nil
+
+	case "price":
+		value, err := d.readPrice()
+		if nil != err { return err }
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64: v.SetFloat(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64: v.SetInt(int64(value))
+		default: return fmt.Errorf("price 标签不支持 %s 类型", v.Type())
+		}
+		return nil
+
+	case "count":
+		start, path := d.pos, d.path
+		count, err := d.readUint(tag.prefix)
+		if nil != err { return err }
+		d.record(d.childPath(path, "count", 0), start, count)
+		if count > uint64(len(d.buffer)-d.pos) { return ErrCodecShort }  // 每组至少1个字节
+
+		slice := reflect.MakeSlice(v.Type(), int(count), int(count))
+		defer func() { d.path = path }()
+		for idx := 0; idx < int(count); idx++ {
+			d.path = d.childPath(path, "", idx)
+			if err = d.decode(slice.Index(idx), codecTag{}); nil != err { return err }
+		}
+		v.Set(slice)
+		return nil
+
+	case "size":
+		start := d.pos
+		size, err := d.readUint(tag.prefix)
+		if nil != err { return err }
+		d.record(d.childPath(d.path, "size", 0), start, size)
+		raw, err := d.next(int(size))
+		if nil != err { return err }
+
+		group := &codecDecoder{buffer: raw, base: d.base+d.pos-len(raw), overlay: d.overlay}
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		for group.pos < len(group.buffer) {
+			var recorded int
+			if nil != d.overlay { recorded = len(*d.overlay) }
+
+			item := reflect.New(v.Type().Elem()).Elem()
+			group.path = d.childPath(d.path, "", slice.Len())
+			err = group.decode(item, codecTag{})
+			if ErrCodecShort == err {
+				// 丢弃结尾不完整的一组数据及其字段位置
+				if nil != d.overlay { *d.overlay = (*d.overlay)[:recorded] }
+				break
+			}
+			if nil != err { return err }
+			slice = reflect.Append(slice, item)
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	path := d.path
+	switch v.Kind() {
+	case reflect.Struct:
+		defer func() { d.path = path }()
+		for idx := 0; idx < v.NumField(); idx++ {
+			field := v.Type().Field(idx)
+			fieldTag, err := parseCodecTag(field.Tag.Get("tdx"))
+			if nil != err { return fmt.Errorf("%s.%s: %v", v.Type().Name(), field.Name, err) }
+			if "-" == fieldTag.kind { continue }
+
+			// 未导出的字段(如 _)只占位, 解码后丢弃
+			fieldValue := v.Field(idx)
+			if !fieldValue.CanSet() { fieldValue = reflect.New(field.Type).Elem() }
+
+			d.path = d.childPath(path, field.Name, 0)
+			if err = d.decode(fieldValue, fieldTag); nil != err { return err }
+		}
+	case reflect.Array:
+		if reflect.Uint8 == v.Type().Elem().Kind() {
+			raw, err := d.next(v.Len())
+			if nil != err { return err }
+			reflect.Copy(v, reflect.ValueOf(raw))
+			return nil
+		}
+		defer func() { d.path = path }()
+		for idx := 0; idx < v.Len(); idx++ {
+			d.path = d.childPath(path, "", idx)
+			if err := d.decode(v.Index(idx), codecTag{}); nil != err { return err }
+		}
+	case reflect.Bool:
+		value, err := d.readUint(v.Kind())
+		if nil != err { return err }
+		v.SetBool(0 != value)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := d.readUint(v.Kind())
+		if nil != err { return err }
+		v.SetUint(value)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := d.readUint(v.Kind())
+		if nil != err { return err }
+		shift := uint(64 - 8*v.Type().Size())
+		v.SetInt(int64(value << shift) >> shift)
+	case reflect.Float32:
+		value, err := d.readUint(v.Kind())
+		if nil != err { return err }
+		v.SetFloat(float64(math.Float32frombits(uint32(value))))
+	case reflect.Float64:
+		value, err := d.readUint(v.Kind())
+		if nil != err { return err }
+		v.SetFloat(math.Float64frombits(value))
+	default:
+		return fmt.Errorf("不支持的字段类型: %s", v.Type())
+	}
+
+	return nil
+}
+
+/**
+ * 按结构体标签把封包数据解码到 v 指向的结构体中, 返回使用的字节数
+ */
+func Unmarshal(data []byte, v interface{}) (int, error) {
+	value := reflect.ValueOf(v)
+	if reflect.Ptr != value.Kind() || value.IsNil() { return 0, fmt.Errorf("Unmarshal 需要非空的指针: %T", v) }
+
+	decoder := &codecDecoder{buffer: data}
+	err := decoder.decode(value.Elem(), codecTag{})
+	return decoder.pos, err
+}
+
+/**
+ * 与 Unmarshal 相同, 同时返回每个字段在封包数据中的位置, 解码失败时返回已解码的字段
+ */
+func Overlay(data []byte, v interface{}) ([]FieldOverlay, error) {
+	var overlay []FieldOverlay
+
+	value := reflect.ValueOf(v)
+	if reflect.Ptr != value.Kind() || value.IsNil() { return nil, fmt.Errorf("Overlay 需要非空的指针: %T", v) }
+
+	decoder := &codecDecoder{buffer: data, overlay: &overlay}
+	err := decoder.decode(value.Elem(), codecTag{})
+	return overlay, err
+}
+
+func writeUint(buffer *bytes.Buffer, kind reflect.Kind, value uint64) {
+	switch kind {
+	case reflect.Uint8, reflect.Int8, reflect.Bool: buffer.WriteByte(byte(value))
+	case reflect.Uint16, reflect.Int16: binary.Write(buffer, binary.LittleEndian, uint16(value))
+	case reflect.Uint32, reflect.Int32, reflect.Float32: binary.Write(buffer, binary.LittleEndian, uint32(value))
+	default: binary.Write(buffer, binary.LittleEndian, value)
+	}
+}
+
+func encode(buffer *bytes.Buffer, v reflect.Value, tag codecTag) error {
+	switch tag.kind {
+	case "gbk":
+		raw := []byte(utils.ConvertTo(v.String(), "utf8", "gbk"))
+		if len(raw) > tag.length { return fmt.Errorf("字符串超过 %d 字节: %s", tag.length, v.String()) }
+		buffer.Write(raw)
+		buffer.Write(make([]byte, tag.length-len(raw)))
+		return nil
+
+	case "price":
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64: buffer.Write(comm.DoubleToBuf(v.Float()))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64: buffer.Write(comm.DoubleToBuf(float64(v.Int())))
+		default: return fmt.Errorf("price 标签不支持 %s 类型", v.Type())
+		}
+		return nil
+
+	case "count":
+		writeUint(buffer, tag.prefix, uint64(v.Len()))
+		for idx := 0; idx < v.Len(); idx++ {
+			if err := encode(buffer, v.Index(idx), codecTag{}); nil != err { return err }
+		}
+		return nil
+
+	case "size":
+		var group bytes.Buffer
+		for idx := 0; idx < v.Len(); idx++ {
+			if err := encode(&group, v.Index(idx), codecTag{}); nil != err { return err }
+		}
+		writeUint(buffer, tag.prefix, uint64(group.Len()))
+		buffer.Write(group.Bytes())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for idx := 0; idx < v.NumField(); idx++ {
+			field := v.Type().Field(idx)
+			fieldTag, err := parseCodecTag(field.Tag.Get("tdx"))
+			if nil != err { return fmt.Errorf("%s.%s: %v", v.Type().Name(), field.Name, err) }
+			if "-" == fieldTag.kind { continue }
+
+			// 未导出的字段(如 _)以0填充
+			fieldValue := v.Field(idx)
+			if !fieldValue.CanInterface() { fieldValue = reflect.New(field.Type).Elem() }
+
+			if err = encode(buffer, fieldValue, fieldTag); nil != err { return err }
+		}
+	case reflect.Array:
+		for idx := 0; idx < v.Len(); idx++ {
+			if err := encode(buffer, v.Index(idx), codecTag{}); nil != err { return err }
+		}
+	case reflect.Bool:
+		var value uint64
+		if v.Bool() { value = 1 }
+		writeUint(buffer, v.Kind(), value)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		writeUint(buffer, v.Kind(), v.Uint())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint(buffer, v.Kind(), uint64(v.Int()))
+	case reflect.Float32:
+		writeUint(buffer, v.Kind(), uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		writeUint(buffer, v.Kind(), math.Float64bits(v.Float()))
+	default:
+		return fmt.Errorf("不支持的字段类型: %s", v.Type())
+	}
+
+	return nil
+}
+
+/**
+ * 按结构体标签把 v 编码为封包数据, v 可以是结构体或其指针
+ */
+func Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if err := encode(&buffer, value, codecTag{}); nil != err { return nil, err }
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,154 @@
+package packet
+
+import (
+	"testing"
+	"encoding/hex"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type codecPriceItem struct {
+	Price    int      `tdx:"price"`
+	Volume   float64  `tdx:"price"`
+	_        [2]byte
+	Reserved uint32   `tdx:"-"`
+}
+
+func TestCodec(t *testing.T) {
+	Convey("测试定长结构及GBK字符串的编解码", t, func() {
+		info := MarketInitInfo{DateSZ: 20180105, DateSH: 20180104, ServerName: "tdxtest", DomainUrl: "127.0.0.1"}
+
+		rawData, err := Marshal(info)
+		So(err, ShouldBeNil)
+		So(rawData, ShouldHaveLength, 107)
+
+		var result MarketInitInfo
+		length, err := Unmarshal(rawData, &result)
+		So(err, ShouldBeNil)
+		So(length, ShouldEqual, len(rawData))
+		So(result, ShouldResemble, info)
+
+		_, err = Marshal(StockBaseItem{Code: "6000001"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("测试带数量前缀的数据组", t, func() {
+		stockBaseList := StockBaseList{Items: []StockBaseItem{
+			{Code: "600000", Unknown1: 0x64, Name: "PFYH", Unknown3: 0x02, Price: 12.59},
+			{Code: "000001", Unknown1: 0x64, Name: "SZZS", Unknown3: 0x02, Price: 3307.17, Bonus2: 1}}}
+
+		rawData, err := Marshal(&stockBaseList)
+		So(err, ShouldBeNil)
+		So(rawData, ShouldHaveLength, 2+2*29)
+		So(rawData[0], ShouldEqual, 2)
+
+		var result StockBaseList
+		_, err = Unmarshal(rawData, &result)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, stockBaseList)
+
+		_, err = Unmarshal(rawData[:len(rawData)-1], &result)
+		So(err, ShouldEqual, ErrCodecShort)
+	})
+
+	Convey("测试带字节数前缀的数据组", t, func() {
+		stockDayList := StockDayList{Items: []StockDayItem{{Date: 20180102, Open: 1259}, {Date: 20180103, Close: 1260}}}
+
+		rawData, err := Marshal(stockDayList)
+		So(err, ShouldBeNil)
+		So(rawData, ShouldHaveLength, 2+4+2*32)
+		So(hex.EncodeToString(rawData[2:6]), ShouldEqual, "40000000")
+
+		var result StockDayList
+		_, err = Unmarshal(rawData, &result)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, stockDayList)
+
+		// 数据组的长度不是结构大小的整数倍时丢弃结尾不完整的一组
+		rawData[2] = 0x3F
+		var truncated StockDayList
+		length, err := Unmarshal(rawData[:len(rawData)-1], &truncated)
+		So(err, ShouldBeNil)
+		So(length, ShouldEqual, len(rawData)-1)
+		So(truncated.Items, ShouldResemble, stockDayList.Items[:1])
+
+		overlay, err := Overlay(rawData[:len(rawData)-1], &truncated)
+		So(err, ShouldBeNil)
+		So(overlay[len(overlay)-1].Name, ShouldStartWith, "Items[0].")
+
+		// 数据组的长度超过封包数据
+		_, err = Unmarshal(rawData[:len(rawData)-2], &result)
+		So(err, ShouldEqual, ErrCodecShort)
+	})
+
+	Convey("测试变长价格及占位字段", t, func() {
+		rawData, _ := hex.DecodeString("AC0E500000")
+
+		var result codecPriceItem
+		length, err := Unmarshal(rawData, &result)
+		So(err, ShouldBeNil)
+		So(length, ShouldEqual, 5)
+		So(result.Price, ShouldEqual, 940)
+		So(result.Volume, ShouldEqual, -16.0)
+
+		encoded, err := Marshal(result)
+		So(err, ShouldBeNil)
+		So(encoded, ShouldResemble, rawData)
+
+		_, err = Unmarshal(rawData[:1], &result)
+		So(err, ShouldEqual, ErrCodecShort)
+	})
+
+	Convey("测试字段在封包数据中的位置", t, func() {
+		stockDayList := StockDayList{Items: []StockDayItem{{Date: 20180102, Open: 1259}, {Date: 20180103, Close: 1260}}}
+		rawData, err := Marshal(stockDayList)
+		So(err, ShouldBeNil)
+
+		var result StockDayList
+		overlay, err := Overlay(rawData, &result)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, stockDayList)
+		So(overlay[1], ShouldResemble, FieldOverlay{"Items.size", 2, 4, uint64(64)})
+		So(overlay[2].Name, ShouldEqual, "Items[0].Date")
+		So(overlay[2].Offset, ShouldEqual, 6)
+		So(overlay[len(overlay)-1].Offset+overlay[len(overlay)-1].Length, ShouldEqual, len(rawData))
+
+		// 解码失败时返回已解码的字段
+		overlay, err = Overlay(rawData[:10], &result)
+		So(err, ShouldEqual, ErrCodecShort)
+		So(overlay, ShouldHaveLength, 2)
+	})
+
+	Convey("测试无效的标签", t, func() {
+		var result struct {
+			Name string `tdx:"gbk"`
+		}
+		_, err := Unmarshal([]byte{0x00}, &result)
+		So(err, ShouldNotBeNil)
+
+		_, err = Unmarshal([]byte{0x00}, result)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("测试请求封包的编码", t, func() {
+		reqNode := GenerateStockBarsItem(PeriodDay, 1, "600000", 20180102, 20180105, 0x8001)
+		So(hex.EncodeToString(reqNode.RawData.([]byte)), ShouldEqual, "010036303030303086ec330189ec33010400")
+
+		period, market, code, err := ParseStockBarsItem(reqNode)
+		So(err, ShouldBeNil)
+		So(period, ShouldEqual, PeriodDay)
+		So(market, ShouldEqual, 1)
+		So(code, ShouldEqual, "600000")
+
+		reqNode = GenerateStockBonus([]StockBonus{{1, [6]byte{'6', '0', '0', '0', '0', '0'}}}, 0x8002)
+		So(hex.EncodeToString(reqNode.RawData.([]byte)), ShouldEqual, "010001363030303030")
+
+		reqNode = GenerateSecurityQuotes([]SecurityItem{
+			{0, [6]byte{'0', '0', '0', '0', '0', '1'}}, {1, [6]byte{'6', '0', '0', '0', '0', '0'}}})
+		So(hex.EncodeToString(reqNode.RawData.([]byte)), ShouldEqual, "050000000000000002000030303030303101363030303030")
+
+		So(GenerateFileMeta("tdxhy.cfg").RawData, ShouldHaveLength, 40)
+		So(GenerateFileChunk("tdxhy.cfg", 0, 0x7530).RawData, ShouldHaveLength, 108)
+		So(GenerateCompanyInfoContent(1, "600000", "600000.txt", 0, 100).RawData, ShouldHaveLength, 102)
+		So(GenerateHeader(0x0FCD, 0x0087, 18, 1, 0x8001), ShouldHaveLength, RequestHeaderSize)
+	})
+}
@@ -0,0 +1,82 @@
+package packet
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"io/ioutil"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+/**
+ * JSON 中以十六进制字符串表示的字节数组
+ */
+type HexBytes []byte
+
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *HexBytes) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); nil != err { return err }
+
+	raw, err := hex.DecodeString(text)
+	if nil != err { return err }
+
+	*h = raw
+	return nil
+}
+
+/**
+ * 保存到磁盘的单个应答封包, 用于分析未知的封包
+ */
+type PacketDump struct {
+	Time    time.Time       `json:"time"`
+	Header  ResponseHeader  `json:"header"`
+	RawBody HexBytes        `json:"raw_body"`   // 收到的包体, 封包被压缩时为解压前的数据
+	Body    HexBytes        `json:"body"`       // 解压后的包体
+}
+
+/**
+ * 应答封包的保存记录
+ */
+func NewPacketDump(respNode ResponseNode) PacketDump {
+	body, _ := respNode.RawData.([]byte)
+	rawBody := respNode.CompressedBody
+	if 0 == len(rawBody) { rawBody = body }
+
+	return PacketDump{Time: time.Now(), Header: respNode.ResponseHeader, RawBody: rawBody, Body: body}
+}
+
+/**
+ * 将应答封包保存到 dir/EventId/CmdId/ 目录下的 JSON 文件中, 返回文件路径
+ */
+func SavePacketDump(dir string, respNode ResponseNode) (string, error) {
+	dump := NewPacketDump(respNode)
+
+	dumpDir := filepath.Join(dir, fmt.Sprintf("0x%04X", dump.Header.EventId), fmt.Sprintf("0x%04X", dump.Header.CmdId))
+	if err := os.MkdirAll(dumpDir, 0755); nil != err { return "", err }
+
+	content, err := json.MarshalIndent(dump, "", "  ")
+	if nil != err { return "", err }
+
+	fileName := fmt.Sprintf("%s_%04X.json", dump.Time.Format("20060102_150405.000000000"), dump.Header.Index)
+	dumpPath := filepath.Join(dumpDir, fileName)
+	return dumpPath, ioutil.WriteFile(dumpPath, content, 0666)
+}
+
+/**
+ * 读取 SavePacketDump 保存的应答封包
+ */
+func LoadPacketDump(path string) (PacketDump, error) {
+	var dump PacketDump
+
+	content, err := ioutil.ReadFile(path)
+	if nil != err { return dump, err }
+
+	err = json.Unmarshal(content, &dump)
+	return dump, err
+}
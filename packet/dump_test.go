@@ -0,0 +1,40 @@
+package packet
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPacketDump(t *testing.T) {
+	Convey("测试应答封包的保存与读取", t, func() {
+		dumpDir, err := ioutil.TempDir("", "ctdx_dump_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dumpDir)
+
+		respNode := ResponseNode{ResponseHeader{PacketFlag: ResponsePacketFlag, IsCompress: 0x1C, Index: 0x8001,
+			CmdId: 0x6C, EventId: 0x044E, BodyLength: 3, BodyMaxLength: 2}, []byte{0x02, 0x00}, []byte{0x78, 0x9C, 0x01}}
+
+		dumpPath, err := SavePacketDump(dumpDir, respNode)
+		So(err, ShouldBeNil)
+		So(filepath.Dir(dumpPath), ShouldEqual, filepath.Join(dumpDir, "0x044E", "0x006C"))
+
+		dump, err := LoadPacketDump(dumpPath)
+		So(err, ShouldBeNil)
+		So(dump.Header, ShouldResemble, respNode.ResponseHeader)
+		So([]byte(dump.Body), ShouldResemble, []byte{0x02, 0x00})
+		So([]byte(dump.RawBody), ShouldResemble, []byte{0x78, 0x9C, 0x01})
+
+		Convey("测试未压缩的封包", func() {
+			respNode.CompressedBody = nil
+			dumpPath, err := SavePacketDump(dumpDir, respNode)
+			So(err, ShouldBeNil)
+
+			dump, err := LoadPacketDump(dumpPath)
+			So(err, ShouldBeNil)
+			So([]byte(dump.RawBody), ShouldResemble, []byte{0x02, 0x00})
+		})
+	})
+}
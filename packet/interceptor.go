@@ -1,85 +1,190 @@
 package packet
 
 import (
+	"io"
 	"net"
 	"bytes"
+	"fmt"
+	"errors"
+	"io/ioutil"
+	"compress/zlib"
 	"encoding/binary"
 
 	"github.com/datochan/gcom/cnet"
 	gbytes "github.com/datochan/gcom/bytes"
 	"github.com/datochan/gcom/utils"
-	"github.com/datochan/gcom/crypto"
 	"github.com/datochan/gcom/logger"
 )
 
+const (
+	ResponsePacketFlag = 0x0074CBB1   // 应答封包的封包标识
+	recvChunkSize      = 1024*5       // 每次从连接中读取的大小
+)
+
+var (
+	ErrBadFlag        = errors.New("封包标识错误")
+	ErrShortBody      = errors.New("封包体不完整")
+	ErrBadCompression = errors.New("封包体解压失败")
+	ErrLengthMismatch = errors.New("封包体长度与包头不一致")
+	ErrOversized      = errors.New("封包体超过允许的最大长度")
+	ErrDropPacket     = errors.New("封包已被拦截器丢弃")
+)
+
+/**
+ * 收发封包的拦截器, 两个方法都可以为空:
+ * Send 在 InterceptSend 中调用(发送请求前), 可以修改请求, 返回错误时放弃发送, 可能被多个协程同时调用
+ * Recv 在拆包后调用, 可以修改应答, 返回 ErrDropPacket 时丢弃该封包, 返回其它错误时断开连接
+ */
+type Interceptor struct {
+	Send func(reqNode *RequestNode) error
+	Recv func(respNode *ResponseNode) error
+}
+
+/**
+ * 拦截器放弃发送请求时 InterceptSend 返回的错误, 连接仍然可用
+ */
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("请求被拦截器放弃: %v", e.Err)
+}
+
 // 只做最简单实现: IPacketProtocol 接口
 type TdxPacketProtocolImpl struct {
-	packetBuffer  *gbytes.LittleEndianStreamImpl   // 封包接收的缓冲区
+	MaxBodySize   int             // 允许的最大包体长度(压缩前及解压后), 0为不限制(包头中的长度字段为2字节, 最大0xFFFF)
+	KeepCompressed bool           // 在应答的 CompressedBody 中保留解压前的包体
+	Interceptors  []Interceptor   // 按顺序调用的拦截器, 连接前设置
+	packetBuffer  bytes.Buffer    // 封包接收的缓冲区, 按需增长
+}
+
+/**
+ * 添加拦截器, 应在连接前调用
+ */
+func (tdx *TdxPacketProtocolImpl) AddInterceptor(interceptor Interceptor) {
+	tdx.Interceptors = append(tdx.Interceptors, interceptor)
 }
 
 func NewDefaultProtocol() *TdxPacketProtocolImpl {
-	return &TdxPacketProtocolImpl{gbytes.NewLittleEndianStream(make([]byte, 1024*1024*5, 1024*1024*5))}
+	return &TdxPacketProtocolImpl{}
 }
 
-func (tdx *TdxPacketProtocolImpl) recvToBuffer(s cnet.ISession) (int, error){
-	tmpBuffer := make([]byte, 1024*5, 1024*5)
+/**
+ * 从连接中读取数据, 直到缓冲区中至少有 size 个字节
+ * 缓冲区为空时连接关闭返回原始错误(如 io.EOF), 已读取部分封包时返回 ErrShortBody
+ */
+func (tdx *TdxPacketProtocolImpl) fillBuffer(s cnet.ISession, size int) error {
+	tmpBuffer := make([]byte, recvChunkSize)
+
+	for tdx.packetBuffer.Len() < size {
+		recvLen, err := s.RawConn().Read(tmpBuffer)
+		if 0 < recvLen { tdx.packetBuffer.Write(tmpBuffer[:recvLen]) }
+		if nil == err { continue }
+
+		if 0 == tdx.packetBuffer.Len() { return err }
+		if tdx.packetBuffer.Len() < size {
+			logger.Error("封包不完整, 需要 %d 字节, 仅收到 %d 字节: %v", size, tdx.packetBuffer.Len(), err)
+			return ErrShortBody
+		}
+	}
 
-	recvLen, err := s.RawConn().Read(tmpBuffer[:])
+	return nil
+}
 
-	if recvLen <= 0 || err != nil {
-		return 0, err
+/**
+ * 解压包体, 解压后的长度必须等于包头中的 BodyMaxLength, 否则返回 ErrLengthMismatch
+ */
+func unCompressBody(body []byte, maxLength int) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if nil != err { return nil, ErrBadCompression }
+	defer reader.Close()
+
+	// 多读一个字节以便发现解压后的数据超过声明的长度
+	result, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxLength)+1))
+	if nil != err {
+		logger.Error("解压封包体失败: %v", err)
+		return nil, ErrBadCompression
+	}
+	if len(result) != maxLength {
+		logger.Error("解压后的封包长度不一致, 声明长度 %d, 解压后长度 %d", maxLength, len(result))
+		return nil, ErrLengthMismatch
 	}
-	err = tdx.packetBuffer.WriteBuff(tmpBuffer[:recvLen])
 
-	return recvLen, err
+	return result, nil
 }
 
 /**
- * 直接返回所有封包内容，不做任何处理
+ * 拆包方法, 读取直到缓冲区中有一个完整的封包, 再依次交给拦截器处理
+ * 封包被拦截器丢弃时返回 nil, nil
  */
 func (tdx *TdxPacketProtocolImpl) ReadPacket(s cnet.ISession) (interface{}, error) {
-	var newBuffer bytes.Buffer
-	var header ResponseHeader
+	respNode, err := tdx.readPacket(s)
+	if nil != err { return nil, err }
 
-	headerSize := utils.SizeStruct(ResponseHeader{})
+	for _, interceptor := range tdx.Interceptors {
+		if nil == interceptor.Recv { continue }
 
-	if tdx.packetBuffer.Length() < headerSize {
-		// 如果buffer的数据小于一个包头则需要开始从tdx获取
-		_, err := tdx.recvToBuffer(s)
-		if tdx.packetBuffer.Length() < headerSize || nil != err {
+		if err = interceptor.Recv(&respNode); ErrDropPacket == err {
+			return nil, nil
+		} else if nil != err {
 			return nil, err
 		}
 	}
 
-	// 预读并解析包头信息
-	tmpHeader, _ := tdx.packetBuffer.PeekBuff(headerSize)
+	return respNode, nil
+}
 
-	newBuffer.Write(tmpHeader)
-	err := binary.Read(&newBuffer, binary.LittleEndian, &header)
+func (tdx *TdxPacketProtocolImpl) readPacket(s cnet.ISession) (ResponseNode, error) {
+	var header ResponseHeader
 
-	if nil != err { return nil, err }
-	bodySize := int(header.BodyLength)
+	headerSize := utils.SizeStruct(ResponseHeader{})
 
-	if tdx.packetBuffer.Length() < bodySize+headerSize {
-		// 如果buffer中的数据不完整则继续从tdx中获取
-		_, err := tdx.recvToBuffer(s)
-		if tdx.packetBuffer.Length() < bodySize+headerSize || nil != err {
-			return nil, err
-		}
+	// 读取并解析包头信息
+	if err := tdx.fillBuffer(s, headerSize); nil != err { return ResponseNode{}, err }
+
+	err := binary.Read(bytes.NewReader(tdx.packetBuffer.Bytes()[:headerSize]), binary.LittleEndian, &header)
+	if nil != err { return ResponseNode{}, err }
+
+	if ResponsePacketFlag != header.PacketFlag {
+		logger.Error("封包标识错误: 0x%08X", header.PacketFlag)
+		return ResponseNode{}, ErrBadFlag
 	}
 
-	// buffer中有完整的封包
-	tdx.packetBuffer.ReadBuff(headerSize)
-	pkgBody, _ := tdx.packetBuffer.ReadBuff(int(header.BodyLength))
+	if 0 < tdx.MaxBodySize && (int(header.BodyLength) > tdx.MaxBodySize || int(header.BodyMaxLength) > tdx.MaxBodySize) {
+		logger.Error("封包体过大: %d(解压后 %d), 最大允许 %d", header.BodyLength, header.BodyMaxLength, tdx.MaxBodySize)
+		return ResponseNode{}, ErrOversized
+	}
+
+	// 读取完整的包体
+	if err = tdx.fillBuffer(s, headerSize+int(header.BodyLength)); nil != err { return ResponseNode{}, err }
+
+	tdx.packetBuffer.Next(headerSize)
+	pkgBody := make([]byte, header.BodyLength)
+	copy(pkgBody, tdx.packetBuffer.Next(int(header.BodyLength)))
 
-	if header.IsCompress & 0x10 != 0{
-		pkgBody = crypto.ZLibUnCompress(pkgBody)
+	var compressedBody []byte
+	if header.IsCompress & 0x10 != 0 {
+		if tdx.KeepCompressed { compressedBody = pkgBody }
+		if pkgBody, err = unCompressBody(pkgBody, int(header.BodyMaxLength)); nil != err { return ResponseNode{}, err }
+	} else if header.BodyLength != header.BodyMaxLength {
+		logger.Error("未压缩的封包长度不一致: %d != %d", header.BodyLength, header.BodyMaxLength)
+		return ResponseNode{}, ErrLengthMismatch
 	}
 
-	// 清理掉已经处理的数据
-	tdx.packetBuffer.CleanBuff()
+	return ResponseNode{header, pkgBody, compressedBody}, nil
+}
 
-	return ResponseNode{header, pkgBody}, nil
+/**
+ * 发送请求前依次交给拦截器处理, 拦截器放弃发送时返回 RejectedError
+ * 组包时不再调用拦截器, 需要在 session.Send 之前调用
+ */
+func (tdx *TdxPacketProtocolImpl) InterceptSend(reqNode *RequestNode) error {
+	for _, interceptor := range tdx.Interceptors {
+		if nil == interceptor.Send { continue }
+		if err := interceptor.Send(reqNode); nil != err { return &RejectedError{err} }
+	}
+	return nil
 }
 
 /**
@@ -87,6 +192,7 @@ func (tdx *TdxPacketProtocolImpl) ReadPacket(s cnet.ISession) (interface{}, erro
  */
 func (tdx *TdxPacketProtocolImpl) BuildPacket(pkgNode interface{}) []byte {
 	requestNode := pkgNode.(RequestNode)
+
 	byteHeader := GenerateHeader(requestNode.EventId, requestNode.CmdId,
 		uint16(utils.SizeStruct(requestNode.RawData)), requestNode.IsRaw, requestNode.Index)
 
@@ -0,0 +1,243 @@
+package packet
+
+import (
+	"io"
+	"net"
+	"time"
+	"bytes"
+	"testing"
+	"compress/zlib"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type pipeSession struct {
+	conn net.Conn
+}
+
+func (s *pipeSession) RawConn() net.Conn { return s.conn }
+func (s *pipeSession) Send(packet interface{}) error { return nil }
+func (s *pipeSession) Close() { s.conn.Close() }
+
+func buildResponse(header ResponseHeader, body []byte) []byte {
+	var newBuffer bytes.Buffer
+	binary.Write(&newBuffer, binary.LittleEndian, header)
+	newBuffer.Write(body)
+	return newBuffer.Bytes()
+}
+
+func compressBody(body []byte) []byte {
+	var zBuffer bytes.Buffer
+	writer := zlib.NewWriter(&zBuffer)
+	writer.Write(body)
+	writer.Close()
+	return zBuffer.Bytes()
+}
+
+/**
+ * 分次写入数据后关闭连接, 返回依次读出的封包及最后的错误
+ */
+func readPackets(protocol *TdxPacketProtocolImpl, chunks ...[]byte) ([]ResponseNode, error) {
+	var respList []ResponseNode
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		for _, chunk := range chunks { server.Write(chunk) }
+		server.Close()
+	}()
+
+	session := &pipeSession{client}
+	for {
+		packet, err := protocol.ReadPacket(session)
+		if nil != err { return respList, err }
+		if nil != packet { respList = append(respList, packet.(ResponseNode)) }
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	body := bytes.Repeat([]byte("ctdx"), 3000)
+	header := ResponseHeader{PacketFlag: ResponsePacketFlag, IsCompress: 0x0C, Index: 1, EventId: 0x0FCD,
+		BodyLength: uint16(len(body)), BodyMaxLength: uint16(len(body))}
+	packet := buildResponse(header, body)
+
+	Convey("测试分多次到达的封包", t, func() {
+		var chunks [][]byte
+		for start := 0; start < len(packet); start += 7 {
+			end := start + 7
+			if end > len(packet) { end = len(packet) }
+			chunks = append(chunks, packet[start:end])
+		}
+
+		respList, err := readPackets(NewDefaultProtocol(), chunks...)
+		So(err, ShouldEqual, io.EOF)
+		So(respList, ShouldHaveLength, 1)
+		So(respList[0].RawData, ShouldResemble, body)
+	})
+
+	Convey("测试一次到达的多个封包", t, func() {
+		respList, err := readPackets(NewDefaultProtocol(), append(append([]byte{}, packet...), packet...))
+		So(err, ShouldEqual, io.EOF)
+		So(respList, ShouldHaveLength, 2)
+		So(respList[1].RawData, ShouldResemble, body)
+	})
+
+	Convey("测试压缩的封包", t, func() {
+		zBody := compressBody(body)
+		zHeader := header
+		zHeader.IsCompress |= 0x10
+		zHeader.BodyLength = uint16(len(zBody))
+
+		respList, err := readPackets(NewDefaultProtocol(), buildResponse(zHeader, zBody))
+		So(err, ShouldEqual, io.EOF)
+		So(respList, ShouldHaveLength, 1)
+		So(respList[0].RawData, ShouldResemble, body)
+
+		Convey("测试无法解压的封包", func() {
+			badBody := bytes.Repeat([]byte{0xFF}, len(zBody))
+			_, err := readPackets(NewDefaultProtocol(), buildResponse(zHeader, badBody))
+			So(err, ShouldEqual, ErrBadCompression)
+		})
+
+		Convey("测试解压后的长度与包头不一致", func() {
+			shortHeader := zHeader
+			shortHeader.BodyMaxLength = uint16(len(body) - 1)
+			_, err := readPackets(NewDefaultProtocol(), buildResponse(shortHeader, zBody))
+			So(err, ShouldEqual, ErrLengthMismatch)
+
+			longHeader := zHeader
+			longHeader.BodyMaxLength = uint16(len(body) + 1)
+			_, err = readPackets(NewDefaultProtocol(), buildResponse(longHeader, zBody))
+			So(err, ShouldEqual, ErrLengthMismatch)
+		})
+	})
+
+	Convey("测试未压缩的包体长度与包头不一致", t, func() {
+		badHeader := header
+		badHeader.BodyMaxLength = header.BodyLength + 1
+		_, err := readPackets(NewDefaultProtocol(), buildResponse(badHeader, body))
+		So(err, ShouldEqual, ErrLengthMismatch)
+	})
+
+	Convey("测试连接断开时封包不完整", t, func() {
+		_, err := readPackets(NewDefaultProtocol(), packet[:len(packet)/2])
+		So(err, ShouldEqual, ErrShortBody)
+
+		_, err = readPackets(NewDefaultProtocol(), packet[:5])
+		So(err, ShouldEqual, ErrShortBody)
+	})
+
+	Convey("测试超过最大长度的封包", t, func() {
+		protocol := NewDefaultProtocol()
+		protocol.MaxBodySize = len(body) - 1
+
+		_, err := readPackets(protocol, packet)
+		So(err, ShouldEqual, ErrOversized)
+
+		// 默认不限制包体长度
+		respList, err := readPackets(NewDefaultProtocol(), packet)
+		So(err, ShouldEqual, io.EOF)
+		So(respList, ShouldHaveLength, 1)
+	})
+
+	Convey("测试封包标识错误", t, func() {
+		badHeader := header
+		badHeader.PacketFlag = 0x12345678
+
+		_, err := readPackets(NewDefaultProtocol(), buildResponse(badHeader, body))
+		So(err, ShouldEqual, ErrBadFlag)
+	})
+}
+
+func TestInterceptor(t *testing.T) {
+	body := []byte{0x02, 0x00}
+	header := ResponseHeader{PacketFlag: ResponsePacketFlag, IsCompress: 0x0C, Index: 0x8001, CmdId: 0x6C,
+		EventId: 0x044E, BodyLength: 2, BodyMaxLength: 2}
+	packet := buildResponse(header, body)
+
+	Convey("测试拦截器修改及丢弃应答", t, func() {
+		var recvList []uint16
+
+		protocol := NewDefaultProtocol()
+		protocol.AddInterceptor(Interceptor{Recv: func(respNode *ResponseNode) error {
+			recvList = append(recvList, respNode.Index)
+			respNode.RawData = []byte{0x03, 0x00}
+			return nil
+		}})
+		protocol.AddInterceptor(DropInterceptor(0x044E, 1))
+
+		respList, err := readPackets(protocol, packet, packet)
+		So(err, ShouldEqual, io.EOF)
+		So(recvList, ShouldResemble, []uint16{0x8001, 0x8001})
+		So(respList, ShouldHaveLength, 1)
+		So(respList[0].RawData, ShouldResemble, []byte{0x03, 0x00})
+	})
+
+	Convey("测试应答校验失败", t, func() {
+		protocol := NewDefaultProtocol()
+		protocol.AddInterceptor(ValidateInterceptor(0x044E, func(respNode ResponseNode) error {
+			return ErrShortBody
+		}))
+
+		respList, err := readPackets(protocol, packet)
+		So(err, ShouldEqual, ErrShortBody)
+		So(respList, ShouldHaveLength, 0)
+	})
+
+	Convey("测试拦截器放弃发送", t, func() {
+		protocol := NewDefaultProtocol()
+		protocol.AddInterceptor(HexDumpInterceptor())
+		protocol.AddInterceptor(Interceptor{Send: func(reqNode *RequestNode) error {
+			if 0x044E == reqNode.EventId { return ErrDropPacket }
+			return nil
+		}})
+
+		reqNode := GenerateMarketStockCount(0)
+		err := protocol.InterceptSend(&reqNode)
+		So(err, ShouldNotBeNil)
+		So(err.(*RejectedError).Err, ShouldEqual, ErrDropPacket)
+
+		reqNode = GenerateMarketInitInfo()
+		So(protocol.InterceptSend(&reqNode), ShouldBeNil)
+	})
+
+	Convey("测试统计应答延迟", t, func() {
+		recorder := NewLatencyRecorder()
+		protocol := NewDefaultProtocol()
+		protocol.AddInterceptor(recorder.Interceptor())
+
+		reqNode := GenerateMarketStockCount(1)
+		reqNode.Index = 0x8001
+		So(protocol.InterceptSend(&reqNode), ShouldBeNil)
+
+		_, err := readPackets(protocol, packet)
+		So(err, ShouldEqual, io.EOF)
+
+		stats := recorder.Stats()
+		So(stats, ShouldContainKey, uint16(0x6C))
+		So(stats[0x6C].Count, ShouldEqual, 1)
+		So(stats[0x6C].Average(), ShouldEqual, stats[0x6C].Max)
+	})
+
+	Convey("测试重连后清除尚未收到应答的请求", t, func() {
+		var observed []uint16
+		recorder := NewLatencyObserver(func(cmdId uint16, latency time.Duration) { observed = append(observed, cmdId) })
+		protocol := NewDefaultProtocol()
+		protocol.AddInterceptor(recorder.Interceptor())
+
+		reqNode := GenerateMarketStockCount(1)
+		reqNode.Index = 0x8001
+		So(protocol.InterceptSend(&reqNode), ShouldBeNil)
+		recorder.Reset()
+
+		_, err := readPackets(protocol, packet)
+		So(err, ShouldEqual, io.EOF)
+		So(recorder.Stats(), ShouldBeEmpty)
+		So(observed, ShouldBeEmpty)
+
+		So(protocol.InterceptSend(&reqNode), ShouldBeNil)
+		_, err = readPackets(protocol, packet)
+		So(err, ShouldEqual, io.EOF)
+		So(observed, ShouldResemble, []uint16{0x6C})
+	})
+}
@@ -0,0 +1,154 @@
+package packet
+
+import (
+	"sync"
+	"time"
+	"encoding/hex"
+
+	"github.com/datochan/gcom/logger"
+)
+
+/**
+ * 以调试级别记录收发封包的十六进制内容
+ */
+func HexDumpInterceptor() Interceptor {
+	return Interceptor{
+		Send: func(reqNode *RequestNode) error {
+			rawData, _ := reqNode.RawData.([]byte)
+			logger.Debug("发送封包 event:0x%04X, cmd:0x%04X, index:%d\n%s",
+				reqNode.EventId, reqNode.CmdId, reqNode.Index, hex.Dump(rawData))
+			return nil
+		},
+		Recv: func(respNode *ResponseNode) error {
+			rawData, _ := respNode.RawData.([]byte)
+			logger.Debug("收到封包 event:0x%04X, cmd:0x%04X, index:%d\n%s",
+				respNode.EventId, respNode.CmdId, respNode.Index, hex.Dump(rawData))
+			return nil
+		},
+	}
+}
+
+/**
+ * 校验指定事件的应答, 校验失败时断开连接
+ */
+func ValidateInterceptor(eventId uint16, validate func(respNode ResponseNode) error) Interceptor {
+	return Interceptor{Recv: func(respNode *ResponseNode) error {
+		if eventId != respNode.EventId { return nil }
+
+		err := validate(*respNode)
+		if nil != err { logger.Error("应答封包(event:0x%04X)校验失败: %v", eventId, err) }
+		return err
+	}}
+}
+
+/**
+ * 丢弃接下来 count 个指定事件的应答, 用于模拟服务器不应答
+ */
+func DropInterceptor(eventId uint16, count int) Interceptor {
+	var lock sync.Mutex
+
+	return Interceptor{Recv: func(respNode *ResponseNode) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if eventId != respNode.EventId || 0 >= count { return nil }
+		count--
+		return ErrDropPacket
+	}}
+}
+
+/**
+ * 单个命令的应答延迟
+ */
+type LatencyStats struct {
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+/**
+ * 平均延迟
+ */
+func (stats LatencyStats) Average() time.Duration {
+	if 0 >= stats.Count { return 0 }
+	return stats.Total / time.Duration(stats.Count)
+}
+
+/**
+ * 按请求索引匹配请求及应答, 统计每个命令(CmdId)的应答延迟
+ */
+type LatencyRecorder struct {
+	lock    sync.Mutex
+	sent    map[uint16]time.Time        // 请求索引 => 发送时间
+	stats   map[uint16]LatencyStats     // CmdId => 应答延迟
+	observe func(cmdId uint16, latency time.Duration)  // 不为空时每个应答的延迟都交给它处理
+}
+
+func NewLatencyRecorder() *LatencyRecorder {
+	return NewLatencyObserver(nil)
+}
+
+/**
+ * 统计延迟的同时把每个应答的延迟交给 observe 处理, 如生成直方图
+ */
+func NewLatencyObserver(observe func(cmdId uint16, latency time.Duration)) *LatencyRecorder {
+	return &LatencyRecorder{sent: make(map[uint16]time.Time), stats: make(map[uint16]LatencyStats), observe: observe}
+}
+
+/**
+ * 清除尚未收到应答的请求, 重新连接后请求索引会重复使用
+ */
+func (r *LatencyRecorder) Reset() {
+	r.lock.Lock()
+	r.sent = make(map[uint16]time.Time)
+	r.lock.Unlock()
+}
+
+/**
+ * 统计延迟的拦截器, 不同连接的请求索引会重复, 每个连接应使用单独的记录器
+ */
+func (r *LatencyRecorder) Interceptor() Interceptor {
+	return Interceptor{
+		Send: func(reqNode *RequestNode) error {
+			// 索引为0的请求在组包时才生成随机索引, 无法与应答对应
+			if 0 == reqNode.Index { return nil }
+
+			r.lock.Lock()
+			r.sent[reqNode.Index] = time.Now()
+			r.lock.Unlock()
+			return nil
+		},
+		Recv: func(respNode *ResponseNode) error {
+			r.lock.Lock()
+			sentTime, exists := r.sent[respNode.Index]
+			if !exists {
+				r.lock.Unlock()
+				return nil
+			}
+			delete(r.sent, respNode.Index)
+
+			latency := time.Since(sentTime)
+			stats := r.stats[respNode.CmdId]
+			stats.Count++
+			stats.Total += latency
+			if latency > stats.Max { stats.Max = latency }
+			r.stats[respNode.CmdId] = stats
+			r.lock.Unlock()
+
+			if nil != r.observe { r.observe(respNode.CmdId, latency) }
+			return nil
+		},
+	}
+}
+
+/**
+ * 各个命令的应答延迟, 以 CmdId 为key
+ */
+func (r *LatencyRecorder) Stats() map[uint16]LatencyStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := make(map[uint16]LatencyStats, len(r.stats))
+	for cmdId, stats := range r.stats { result[cmdId] = stats }
+	return result
+}
@@ -1,12 +1,11 @@
 package packet
 
 import (
+	"fmt"
 	"time"
-	"bytes"
 	"strconv"
 	"math/rand"
 	"encoding/hex"
-	"encoding/binary"
 
 	"github.com/datochan/gcom/utils"
 	"github.com/datochan/gcom/crypto"
@@ -15,26 +14,27 @@ import (
 
 // 通达信通讯封包包头结构
 type header struct {
-	flag  byte      // 固定为0x0C
-	index uint16    // idx(先随机，发现特殊值再单独处理)
-	cmdId uint16    // 具体命令的子标识
-	isRaw byte      // 是否是未压缩的原始封包(已知封包全是0,待发现特殊值再特殊封装)
-	bodyLength uint16        // H: 分别是封包长度(封包长度+2字节)
-	bodyMaxLength  uint16    // H: 解压所需要的空间大小(已知两个相等待发现特殊值再特殊处理)
-	eventId        uint16    // H: 事件标识, 靠此字段可以确定封包的类别
+	Flag  byte      // 固定为0x0C
+	Index uint16    // idx(先随机，发现特殊值再单独处理)
+	CmdId uint16    // 具体命令的子标识
+	IsRaw byte      // 是否是未压缩的原始封包(已知封包全是0,待发现特殊值再特殊封装)
+	BodyLength uint16        // H: 分别是封包长度(封包长度+2字节)
+	BodyMaxLength  uint16    // H: 解压所需要的空间大小(已知两个相等待发现特殊值再特殊处理)
+	EventId        uint16    // H: 事件标识, 靠此字段可以确定封包的类别
 }
 
+const RequestHeaderSize = 12  // 请求封包包头的长度
+
 /**
  * 生成封包包头
  */
 func GenerateHeader(eventId uint16, cmdId uint16, pkgLen uint16, isRaw byte, idx uint16) []byte {
 	if idx == 0 { idx = uint16(rand.Intn(0x7fff)) }
 
-	newBuffer := new(bytes.Buffer)
 	rand.Seed(time.Now().UnixNano())
 
-	binary.Write(newBuffer, binary.LittleEndian, header{0x0C,idx,cmdId,isRaw,pkgLen+2,pkgLen+2,eventId})
-	return newBuffer.Bytes()
+	rawData, _ := Marshal(header{0x0C,idx,cmdId,isRaw,pkgLen+2,pkgLen+2,eventId})
+	return rawData
 }
 
 /**
@@ -48,20 +48,29 @@ type RequestNode struct{
 	RawData   interface{}    // 原始请求封包体的原始数据
 }
 
+/**
+ * 按结构体标签编码请求封包体, 编码失败时记录日志, 封包体为空
+ */
+func marshalRequest(reqNode *RequestNode, v interface{}) {
+	rawData, err := Marshal(v)
+	if nil != err { logger.Error("生成请求封包失败, EventId: 0x%04X, Err: %v", reqNode.EventId, err) }
+
+	reqNode.RawData = rawData
+}
+
 type deviceInfo struct {
-	unknown1    [110]byte // 0
-	unknown2    uint32    // 0x01040000
-	unknown3    uint32    // 0
-	mainVersion float32
-	coreVersion float32
-	unknown4    uint32     // 0
-	unknown5    [47]byte   // 0
-	macAddr     [12]byte   // rand
-	unknown6    [89]byte   // 0
+	Unknown1    [110]byte // 0
+	Unknown2    uint32    // 0x01040000
+	Unknown3    uint32    // 0
+	MainVersion float32
+	CoreVersion float32
+	Unknown4    uint32     // 0
+	Unknown5    [47]byte   // 0
+	MacAddr     [12]byte   // rand
+	Unknown6    [89]byte   // 0
 }
 
 func GenerateDeviceNode(mainVersion , coreVersion float32) RequestNode {
-	var newBuffer bytes.Buffer
 	var reqNode RequestNode
 	reqNode.EventId = 0x0B
 	reqNode.CmdId = 0x007B
@@ -69,9 +78,9 @@ func GenerateDeviceNode(mainVersion , coreVersion float32) RequestNode {
 	macAddr := [12]byte{}
 	copy(macAddr[:], []byte(utils.RandomMacAddress()))
 	deviceInfo := deviceInfo{[110]byte{}, 0x01040000, 0, mainVersion, coreVersion, 0,[47]byte{}, macAddr, [89]byte{}}
-	binary.Write(&newBuffer, binary.LittleEndian, deviceInfo)
+	rawData, _ := Marshal(deviceInfo)
 
-	pkgBuffer := crypto.Blowfish(newBuffer.Bytes())
+	pkgBuffer := crypto.Blowfish(rawData)
 
 	reqNode.RawData = pkgBuffer
 
@@ -89,12 +98,11 @@ func GenerateMarketInitInfo() RequestNode {
 }
 
 type marketStockCount struct {
-	market      uint16 // 深圳0, 上海1
-	currentDate uint32 // 当前日期 yyyymmdd
+	Market      uint16 // 深圳0, 上海1
+	CurrentDate uint32 // 当前日期 yyyymmdd
 }
 
 func GenerateMarketStockCount(market int) RequestNode {
-	var newBuffer bytes.Buffer
 	var reqNode RequestNode
 	reqNode.EventId = 0x044E
 	reqNode.IsRaw = 1
@@ -108,8 +116,7 @@ func GenerateMarketStockCount(market int) RequestNode {
 	}
 	currentDate,_ :=strconv.Atoi(time.Now().Format("20060102"))
 
-	binary.Write(&newBuffer, binary.LittleEndian, marketStockCount{uint16(market), uint32(currentDate)})
-	reqNode.RawData = newBuffer.Bytes()
+	marshalRequest(&reqNode, marketStockCount{uint16(market), uint32(currentDate)})
 
 	return reqNode
 }
@@ -128,12 +135,11 @@ func GenerateNotice() RequestNode {
 
 // 请求股票基础信息
 type marketStockBase struct {
-	market		uint16 // 深圳0, 上海1
-	stockOffset		uint16 // 要获取的股票信息偏移
+	Market		uint16 // 深圳0, 上海1
+	StockOffset		uint16 // 要获取的股票信息偏移
 }
 
 func GenerateMarketStockBase(market uint16, offset uint16) RequestNode {
-	var newBuffer bytes.Buffer
 	var reqNode RequestNode
 	reqNode.EventId = 0x0450
 	reqNode.IsRaw = 1
@@ -146,8 +152,7 @@ func GenerateMarketStockBase(market uint16, offset uint16) RequestNode {
 		reqNode.CmdId = 0x006E
 	}
 
-	binary.Write(&newBuffer, binary.LittleEndian, marketStockBase{market, offset})
-	reqNode.RawData = newBuffer.Bytes()
+	marshalRequest(&reqNode, marketStockBase{market, offset})
 
 	return reqNode
 }
@@ -157,65 +162,299 @@ type StockBonus struct {
 	Code   [6]byte
 }
 
+// 权息请求结构
+type stockBonusList struct {
+	Stocks []StockBonus `tdx:"count=uint16"`
+}
+
 func GenerateStockBonus(stocks []StockBonus, index uint16) RequestNode {
-	var newBuffer bytes.Buffer
 	var reqNode RequestNode
 	reqNode.CmdId = 0x0076
 	reqNode.EventId = 0x000F
 	reqNode.IsRaw = 1
 	reqNode.Index = index
 
-	count := uint16(len(stocks))
-	err := binary.Write(&newBuffer, binary.LittleEndian, count)
-	if nil != err {
-		logger.Error("generate stock bonus err %v", err)
-	}
-
-	binary.Write(&newBuffer, binary.LittleEndian, stocks)
-	reqNode.RawData = newBuffer.Bytes()
+	marshalRequest(&reqNode, stockBonusList{stocks})
 
 	return reqNode
 }
 
-// 日线行情信息结构
+// K线周期, 取值即请求中的周期标识
+type KLinePeriod uint16
+
+const (
+	PeriodMin5    KLinePeriod = 0x00  // 5分钟线
+	PeriodMin15   KLinePeriod = 0x01  // 15分钟线
+	PeriodMin30   KLinePeriod = 0x02  // 30分钟线
+	PeriodMin60   KLinePeriod = 0x03  // 60分钟线
+	PeriodDay     KLinePeriod = 0x04  // 日线
+	PeriodWeek    KLinePeriod = 0x05  // 周线
+	PeriodMonth   KLinePeriod = 0x06  // 月线
+	PeriodMin1    KLinePeriod = 0x08  // 1分钟线
+	PeriodQuarter KLinePeriod = 0x0A  // 季线
+	PeriodYear    KLinePeriod = 0x0B  // 年线
+)
+
+const (
+	stockDayCmdId  = 0x0087  // 日线及以上周期的命令标识
+	stockMinsCmdId = 0x008D  // 分钟线的命令标识
+)
+
+/**
+ * 是否是分钟级别的K线(应答数据为 StockMinsItem 结构)
+ */
+func (period KLinePeriod) IsMinute() bool {
+	switch period {
+	case PeriodMin1, PeriodMin5, PeriodMin15, PeriodMin30, PeriodMin60:
+		return true
+	}
+	return false
+}
+
+// K线行情信息结构
 type stockHistoryItem struct {
-	market   uint16     // 0: 深圳; 1: 上海
-	code     [6]byte
-	start    uint32
-	end      uint32
-	unknown1 uint16
+	Market   uint16     // 0: 深圳; 1: 上海
+	Code     string     `tdx:"gbk,6"`
+	Start    uint32
+	End      uint32
+	Period   uint16     // K线周期
 }
 
-func GenerateStockDayItem(market uint16, code string, start, end uint32, index uint16) RequestNode {
-	var newBuffer bytes.Buffer
+/**
+ * 请求指定周期的K线数据
+ */
+func GenerateStockBarsItem(period KLinePeriod, market uint16, code string, start, end uint32, index uint16) RequestNode {
 	var reqNode RequestNode
-	var byCode [6]byte
-	reqNode.CmdId = 0x0087
+	reqNode.CmdId = stockDayCmdId
 	reqNode.EventId = 0x0FCD
 	reqNode.IsRaw = 1
 	reqNode.Index = index
 
-	copy(byCode[:], []byte(code))
+	if period.IsMinute() { reqNode.CmdId = stockMinsCmdId }
 
-	binary.Write(&newBuffer, binary.LittleEndian, stockHistoryItem{market, byCode, start, end, 0x0004})
-	reqNode.RawData = newBuffer.Bytes()
+	marshalRequest(&reqNode, stockHistoryItem{market, code, start, end, uint16(period)})
 
 	return reqNode
 }
 
+/**
+ * 解析 GenerateStockBarsItem 生成的请求, 用于回放封包记录时确定应答所属的证券及周期
+ */
+func ParseStockBarsItem(reqNode RequestNode) (period KLinePeriod, market uint16, code string, err error) {
+	var item stockHistoryItem
+
+	rawData, _ := reqNode.RawData.([]byte)
+	if _, err = Unmarshal(rawData, &item); nil != err { return 0, 0, "", fmt.Errorf("无效的K线请求: %v", err) }
+
+	return KLinePeriod(item.Period), item.Market, item.Code, nil
+}
+
+func GenerateStockDayItem(market uint16, code string, start, end uint32, index uint16) RequestNode {
+	return GenerateStockBarsItem(PeriodDay, market, code, start, end, index)
+}
+
 func GenerateStockMinsItem(market uint16, code string, start, end uint32, index uint16) RequestNode {
-    var newBuffer bytes.Buffer
+	return GenerateStockBarsItem(PeriodMin5, market, code, start, end, index)
+}
+
+// 实时行情请求中的证券信息
+type SecurityItem struct {
+	Market byte      // 0: 深圳; 1: 上海
+	Code   [6]byte
+}
+
+// 实时行情请求结构
+type securityQuotes struct {
+	Unknown1 uint16     // 固定0x05
+	Unknown2 uint32
+	Unknown3 uint16
+	Stocks   []SecurityItem `tdx:"count=uint16"`
+}
+
+/**
+ * 请求一批证券的实时行情快照
+ */
+func GenerateSecurityQuotes(stocks []SecurityItem) RequestNode {
 	var reqNode RequestNode
-	var byCode [6]byte
-    reqNode.CmdId = 0x008D
-	reqNode.EventId = 0x0FCD
+	reqNode.CmdId = 0x0063
+	reqNode.EventId = 0x053E
+	reqNode.IsRaw = 2
+
+	marshalRequest(&reqNode, securityQuotes{0x05, 0, 0, stocks})
+
+	return reqNode
+}
+
+// 分笔成交请求结构
+type stockTransaction struct {
+	Market uint16     // 0: 深圳; 1: 上海
+	Code   string     `tdx:"gbk,6"`
+	Start  uint16     // 偏移量(由最新一笔往前计算)
+	Count  uint16     // 请求的数量
+}
+
+/**
+ * 请求当日的分笔成交数据
+ */
+func GenerateTransaction(market uint16, code string, start, count uint16) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x0101
+	reqNode.EventId = 0x0FC5
 	reqNode.IsRaw = 1
-	reqNode.Index = index
 
-	copy(byCode[:], []byte(code))
+	marshalRequest(&reqNode, stockTransaction{market, code, start, count})
+
+	return reqNode
+}
+
+// 历史分笔成交请求结构
+type stockHistoryTransaction struct {
+	Date   uint32     // yyyymmdd
+	Market uint16     // 0: 深圳; 1: 上海
+	Code   string     `tdx:"gbk,6"`
+	Start  uint16     // 偏移量(由最后一笔往前计算)
+	Count  uint16     // 请求的数量
+}
+
+/**
+ * 请求指定日期的历史分笔成交数据
+ */
+func GenerateHistoryTransaction(market uint16, code string, date uint32, start, count uint16) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x0001
+	reqNode.EventId = 0x0FB5
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, stockHistoryTransaction{date, market, code, start, count})
+
+	return reqNode
+}
+
+// 当日分时数据请求结构
+type minuteTimeShare struct {
+	Market  uint16     // 0: 深圳; 1: 上海
+	Code    string     `tdx:"gbk,6"`
+	Unknown uint32
+}
+
+/**
+ * 请求当日的分时数据
+ */
+func GenerateMinuteTimeShare(market uint16, code string) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x0100
+	reqNode.EventId = 0x051D
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, minuteTimeShare{market, code, 0})
+
+	return reqNode
+}
+
+// 历史分时数据请求结构
+type historyMinuteTimeShare struct {
+	Date   uint32     // yyyymmdd
+	Market byte       // 0: 深圳; 1: 上海
+	Code   string     `tdx:"gbk,6"`
+}
+
+/**
+ * 请求指定日期的历史分时数据
+ */
+func GenerateHistoryMinuteTimeShare(market byte, code string, date uint32) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x0100
+	reqNode.EventId = 0x0FB4
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, historyMinuteTimeShare{date, market, code})
+
+	return reqNode
+}
+
+// F10资料目录请求结构
+type companyInfoCategory struct {
+	Market  uint16     // 0: 深圳; 1: 上海
+	Code    string     `tdx:"gbk,6"`
+	Unknown uint32
+}
+
+/**
+ * 请求F10资料的目录
+ */
+func GenerateCompanyInfoCategory(market uint16, code string) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x009B
+	reqNode.EventId = 0x02CF
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, companyInfoCategory{market, code, 0})
+
+	return reqNode
+}
+
+// F10资料内容请求结构
+type companyInfoContent struct {
+	Market   uint16     // 0: 深圳; 1: 上海
+	Code     string     `tdx:"gbk,6"`
+	Unknown1 uint16
+	FileName string     `tdx:"gbk,80"`  // 目录中的文件名
+	Start    uint32     // 目录中的起始位置
+	Length   uint32     // 目录中的内容长度
+	Unknown2 uint32
+}
+
+/**
+ * 请求F10资料某一目录的内容
+ */
+func GenerateCompanyInfoContent(market uint16, code string, fileName string, start, length uint32) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x009C
+	reqNode.EventId = 0x02D0
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, companyInfoContent{market, code, 0, fileName, start, length, 0})
+
+	return reqNode
+}
+
+// 文件元信息请求结构
+type fileMeta struct {
+	FileName string     `tdx:"gbk,40"`
+}
+
+/**
+ * 请求服务器上文件的元信息(文件大小及校验值)
+ * fileName: 如 tdxhy.cfg, tdxzs.cfg, block_zs.dat 等
+ */
+func GenerateFileMeta(fileName string) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x0069
+	reqNode.EventId = 0x02C5
+	reqNode.IsRaw = 1
+
+	marshalRequest(&reqNode, fileMeta{fileName})
+
+	return reqNode
+}
+
+// 文件分块下载请求结构
+type fileChunk struct {
+	Offset   uint32     // 本次下载的起始位置
+	Size     uint32     // 本次下载的长度
+	FileName string     `tdx:"gbk,100"`
+}
+
+/**
+ * 分块下载服务器上的文件
+ */
+func GenerateFileChunk(fileName string, offset, size uint32) RequestNode {
+	var reqNode RequestNode
+	reqNode.CmdId = 0x006A
+	reqNode.EventId = 0x06B9
+	reqNode.IsRaw = 1
 
-	binary.Write(&newBuffer, binary.LittleEndian, stockHistoryItem{market, byCode, start, end, 0})
-	reqNode.RawData = newBuffer.Bytes()
+	marshalRequest(&reqNode, fileChunk{offset, size, fileName})
 
 	return reqNode
 }
@@ -20,6 +20,7 @@ type ResponseHeader struct {
 type ResponseNode struct{
     ResponseHeader           // 收到的封包头信息
     RawData   interface{}    // 原始相应封包体的原始数据
+    CompressedBody []byte    // 解压前的包体, 仅在封包被压缩且协议设置了 KeepCompressed 时有效
 }
 
 /**
@@ -35,17 +36,17 @@ type MarketInitInfo struct {
     Unknown3   uint32     // L: unknown
     Unknown4   uint16     // H: unknown
     Unknown5   uint32     // L: unknown
-    ServerName [21]byte   // 21s: 服务器名称
-    DomainUrl  [18]byte   // 18s: domain
+    ServerName string     `tdx:"gbk,21"`  // 21s: 服务器名称
+    DomainUrl  string     `tdx:"gbk,18"`  // 18s: domain
 }
 
 /**
  * 股票基础信息
  */
 type StockBaseItem struct {
-    Code         [6]byte    // 股票代码
+    Code         string     `tdx:"gbk,6"`  // 股票代码
     Unknown1     uint16     // 未知 固定0x64
-    Name         [8]byte    // 股票名称
+    Name         string     `tdx:"gbk,8"`  // 股票名称
     Unknown2     uint32     // 未知
     Unknown3     byte       // 未知 固定0x02
     Price        float32    // 价格(昨收)
@@ -53,12 +54,19 @@ type StockBaseItem struct {
     Bonus2       uint16     // 权息数量
 }
 
+/**
+ * 股票列表应答: 股票数量及每只股票的基础信息
+ */
+type StockBaseList struct {
+    Items        []StockBaseItem  `tdx:"count=uint16"`
+}
+
 /**
  * 股票权息数据结构
  */
 type StockBonusItem struct {
     Market       byte    // B: 市场(market): 0深, 1沪
-    Code         [6]byte // 6s: 股票代码(code)
+    Code         string  `tdx:"gbk,6"`  // 6s: 股票代码(code)
     Unknown1     byte    // B: 股票代码的0结束符(python解析麻烦,所以单独解析出来不使用)
     Date         int32   // L: 日期(date)
     Type         byte    // B: 分红配股类型(type): 1标识除权除息, 2: 配送股上市; 3: 非流通股上市; 4:未知股本变动; 5: 股本变动,6: 增发新股, 7: 股本回购, 8: 增发新股上市, 9:转配股上市
@@ -68,6 +76,22 @@ type StockBonusItem struct {
     Rate         float32 // 配股比例
 }
 
+/**
+ * 单只股票的权息数据
+ */
+type StockBonusGroup struct {
+    Market       byte
+    Code         string            `tdx:"gbk,6"`
+    Items        []StockBonusItem  `tdx:"count=uint16"`
+}
+
+/**
+ * 权息数据应答: 股票数量及每只股票的权息数据
+ */
+type StockBonusList struct {
+    Groups       []StockBonusGroup `tdx:"count=uint16"`
+}
+
 /**
  * 日线数据结构
  */
@@ -86,6 +110,37 @@ type StockDayItem struct {
      Unknown1   uint32
 }
 
+/**
+ * 指数日线数据结构, 与日线结构相同, 但最后4个字节为上涨家数和下跌家数
+ */
+type IndexDayItem struct {
+     Date		uint32
+     Open       uint32
+     High       uint32
+     Low        uint32
+     Close      uint32
+     Amount     float32
+     Volume     uint32
+     UpCount    uint16
+     DownCount  uint16
+}
+
+/**
+ * 日线应答: 标识符及以字节计长度的日线数据
+ */
+type StockDayList struct {
+     Unknown1   uint16
+     Items      []StockDayItem  `tdx:"size=uint32"`
+}
+
+/**
+ * 指数日线应答
+ */
+type IndexDayList struct {
+     Unknown1   uint16
+     Items      []IndexDayItem  `tdx:"size=uint32"`
+}
+
 /**
  * 五分钟线结构
  */
@@ -104,6 +159,14 @@ type StockMinsItem struct {
     Unknown1   uint32
 }
 
+/**
+ * 分钟线应答
+ */
+type StockMinsList struct {
+    Unknown1   uint16
+    Items      []StockMinsItem  `tdx:"size=uint32"`
+}
+
 /**
  * 财报数据
  */
@@ -122,3 +185,30 @@ type ReportItem struct {    //6s1c1L
 type ReportData struct {
     Prices    [264]float32
 }
+
+/**
+ * F10资料目录
+ */
+type CompanyInfoCategoryItem struct {
+    Name       string     `tdx:"gbk,64"`  // 目录名称
+    FileName   string     `tdx:"gbk,80"`  // 所在文件名
+    Start      uint32     // 在文件中的起始位置
+    Length     uint32     // 内容长度
+}
+
+/**
+ * F10资料目录应答: 目录数量及每个目录的信息
+ */
+type CompanyInfoCategoryList struct {
+    Items      []CompanyInfoCategoryItem  `tdx:"count=uint16"`
+}
+
+/**
+ * 服务器文件的元信息
+ */
+type FileMetaItem struct {
+    Size       uint32     // 文件大小
+    Unknown1   byte
+    Hash       [32]byte   // 文件校验值
+    Unknown2   byte
+}
@@ -0,0 +1,215 @@
+package ctdx
+
+import (
+	"fmt"
+	"sync"
+	"context"
+
+	"github.com/datochan/ctdx/comm"
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+const poolShardSize = 50  // 每次分配给一个连接的证券数量
+
+/**
+ * 多个连接组成的连接池, 批量下载时把证券列表分片后由各个连接并行处理
+ */
+type TdxPool struct {
+	Clients     []*TdxClient
+	Concurrency int               // 同时工作的连接数, 0 为全部连接
+	Finished    chan interface{}  // UpdateXXX 的工作队列全部完成后发送的通知(有缓冲), 与 TdxClient.Finished 相同
+}
+
+/**
+ * 创建包含 size 个连接的连接池
+ */
+func NewTdxPool(configure comm.IConfigure, size int) *TdxPool {
+	pool := &TdxPool{Finished: make(chan interface{}, 1)}
+	for idx := 0; idx < size; idx++ {
+		pool.Clients = append(pool.Clients, NewDefaultTdxClient(configure))
+	}
+
+	return pool
+}
+
+/**
+ * 连接所有服务器, 有多个候选服务器时各个连接分散到数据最新的服务器上
+ */
+func (pool *TdxPool) Conn(ctx context.Context) error {
+	if 0 == len(pool.Clients) { return fmt.Errorf("连接池中没有任何连接") }
+
+	hosts := pool.Clients[0].candidateHosts()
+	spread := 1
+	if 1 < len(hosts) {
+		probeList := ProbeHosts(ctx, pool.Clients[0].Configure, hosts)
+		if nil != probeList[0].Err { return fmt.Errorf("所有服务器均连接失败: %v", probeList[0].Err) }
+
+		hosts = make([]string, len(probeList))
+		for idx, probe := range probeList {
+			hosts[idx] = probe.Host
+			if 0 < idx && nil == probe.Err && probeList[0].LastDate == probe.LastDate { spread = idx+1 }
+		}
+	}
+
+	errList := make([]error, len(pool.Clients))
+	var wg sync.WaitGroup
+	for idx, client := range pool.Clients {
+		client.Hosts, client.hostIdx, client.hostsRanked = hosts, idx % spread, true
+
+		wg.Add(1)
+		go func(idx int, client *TdxClient) {
+			defer wg.Done()
+			errList[idx] = client.Conn(ctx)
+		}(idx, client)
+	}
+	wg.Wait()
+
+	for _, err := range errList {
+		if nil != err {
+			pool.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+ * 通知 Finished 的消费方更新结束, 没有消费方时不阻塞
+ */
+func (pool *TdxPool) notifyFinished() {
+	select {
+	case pool.Finished <- nil:
+	default:
+	}
+}
+
+/**
+ * 关闭所有连接
+ */
+func (pool *TdxPool) Close() {
+	for _, client := range pool.Clients { client.Close() }
+}
+
+/**
+ * 把证券列表按 shardSize 分片放入工作队列, 由 Concurrency 个连接并行处理
+ * 任一分片出错时取消其余的工作并返回该错误
+ */
+func (pool *TdxPool) run(ctx context.Context, securities []string, shardSize int,
+	work func(ctx context.Context, client *TdxClient, shard []string, offset int) error) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	workers := pool.Concurrency
+	if 0 >= workers || workers > len(pool.Clients) { workers = len(pool.Clients) }
+	if 0 == workers { return fmt.Errorf("连接池中没有任何连接") }
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan int, (len(securities)+shardSize-1)/shardSize)
+	for offset := 0; offset < len(securities); offset += shardSize { queue <- offset }
+	close(queue)
+
+	for idx := 0; idx < workers; idx++ {
+		wg.Add(1)
+		go func(client *TdxClient) {
+			defer wg.Done()
+			for offset := range queue {
+				if nil != ctx.Err() { return }
+
+				end := offset + shardSize
+				if end > len(securities) { end = len(securities) }
+
+				if err := work(ctx, client, securities[offset:end], offset); nil != err {
+					once.Do(func() { firstErr = err; cancel() })
+					return
+				}
+			}
+		}(pool.Clients[idx])
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+/**
+ * 并行更新日线数据
+ */
+func (pool *TdxPool) UpdateDays(ctx context.Context) error {
+	return pool.UpdateBars(ctx, pkg.PeriodDay)
+}
+
+/**
+ * 并行更新5分钟线数据
+ */
+func (pool *TdxPool) UpdateMins(ctx context.Context) error {
+	return pool.UpdateBars(ctx, pkg.PeriodMin5)
+}
+
+/**
+ * 并行更新指定周期的K线数据, 同一只证券的数据只由一个连接写入, 全部完成后通知 Finished
+ */
+func (pool *TdxPool) UpdateBars(ctx context.Context, period pkg.KLinePeriod) error {
+	if 0 == len(pool.Clients) { return fmt.Errorf("连接池中没有任何连接") }
+
+	_, securities, err := barsSecurities(pool.Clients[0].Configure, period)
+	if nil != err { return err }
+
+	err = pool.run(ctx, securities, poolShardSize,
+		func(ctx context.Context, client *TdxClient, shard []string, offset int) error {
+			return client.updateBars(ctx, period, shard)
+		})
+	if nil != err { return err }
+
+	pool.notifyFinished()
+	return nil
+}
+
+/**
+ * 并行获取多只证券(市场+代码, 如: 1600000)的日线数据, 按 codes 的顺序合并返回
+ */
+func (pool *TdxPool) GetDayBars(ctx context.Context, codes []string, start, end int) ([]StockDayModel, error) {
+	var dayList []StockDayModel
+	resultList := make([][]StockDayModel, len(codes))
+
+	for _, strCode := range codes {
+		if _, err := parseSecurityCode(strCode); nil != err { return nil, err }
+	}
+
+	err := pool.run(ctx, codes, 1, func(ctx context.Context, client *TdxClient, shard []string, offset int) error {
+		stockItem, _ := parseSecurityCode(shard[0])
+		pageList, err := client.GetDayBars(ctx, int(stockItem.Market), string(stockItem.Code[:]), start, end)
+		if nil != err { return err }
+
+		resultList[offset] = pageList
+		return nil
+	})
+	if nil != err { return nil, err }
+
+	for _, pageList := range resultList { dayList = append(dayList, pageList...) }
+
+	return dayList, nil
+}
+
+/**
+ * 并行获取多只证券的实时行情, 按 codes 的顺序合并返回
+ */
+func (pool *TdxPool) GetQuotes(ctx context.Context, codes []string) ([]QuoteModel, error) {
+	var quoteList []QuoteModel
+	resultList := make([][]QuoteModel, (len(codes)+quotesBatchSize-1)/quotesBatchSize)
+
+	err := pool.run(ctx, codes, quotesBatchSize, func(ctx context.Context, client *TdxClient, shard []string, offset int) error {
+		batchList, err := client.GetQuotes(ctx, shard)
+		if nil != err { return err }
+
+		resultList[offset/quotesBatchSize] = batchList
+		return nil
+	})
+	if nil != err { return nil, err }
+
+	for _, batchList := range resultList { quoteList = append(quoteList, batchList...) }
+
+	return quoteList, nil
+}
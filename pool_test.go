@@ -0,0 +1,102 @@
+package ctdx
+
+import (
+	"os"
+	"context"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/ctdx/comm"
+	"github.com/datochan/ctdx/tdxtest"
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试连接池并行获取数据", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		backupServer, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer backupServer.Close()
+
+		configure := new(comm.Conf)
+		configure.Tdx.Server.DataHosts = []string{server.Addr(), backupServer.Addr()}
+
+		pool := NewTdxPool(configure, 4)
+		So(pool.Conn(ctx), ShouldBeNil)
+		defer pool.Close()
+
+		// 连接分散到两个服务器上
+		So(pool.Clients[0].CurrentHost(), ShouldNotEqual, pool.Clients[1].CurrentHost())
+
+		codes := []string{"0000001", "0399001", "1600000", "1000001"}
+		dayList, err := pool.GetDayBars(ctx, codes, 20180102, 20180105)
+		So(err, ShouldBeNil)
+		So(dayList, ShouldHaveLength, 16)
+		for idx, strCode := range codes {
+			So(dayList[idx*4].Code, ShouldEqual, strCode[1:])
+			So(dayList[idx*4].Date, ShouldEqual, 20180102)
+		}
+
+		// 每只证券只请求一次
+		barsEventId := pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId
+		countBars := func(server *tdxtest.Server) int {
+			count := 0
+			for _, req := range server.Requests() {
+				if barsEventId == req.EventId { count++ }
+			}
+			return count
+		}
+		So(countBars(server)+countBars(backupServer), ShouldEqual, 4)
+
+		Convey("测试限制同时工作的连接数", func() {
+			pool.Concurrency = 1
+			defer func() { pool.Concurrency = 0 }()
+
+			dayList, err := pool.GetDayBars(ctx, codes, 20180102, 20180105)
+			So(err, ShouldBeNil)
+			So(dayList, ShouldHaveLength, 16)
+		})
+
+		Convey("测试无效的证券代码", func() {
+			_, err := pool.GetDayBars(ctx, []string{"1600000", "600000"}, 20180102, 20180105)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("测试连接池更新K线数据", t, func() {
+		dataPath, err := ioutil.TempDir("", "ctdx_pool_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataPath)
+
+		// 证券列表中只有债券, 没有需要更新K线数据的证券
+		configure := new(comm.Conf)
+		configure.App.DataPath = dataPath + "/"
+		configure.Tdx.Files.StockList = "stock_list.csv"
+		stockList := "code,name,market,unknown1,unknown2,unknown3,price,bonus1,bonus2\n019547,GZ1915,1,100,0,2,100.0,0,0\n"
+		So(ioutil.WriteFile(filepath.Join(dataPath, "stock_list.csv"), []byte(stockList), 0666), ShouldBeNil)
+
+		pool := NewTdxPool(configure, 2)
+		So(pool.UpdateDays(ctx), ShouldBeNil)
+		So(len(pool.Finished), ShouldEqual, 1)
+
+		// 不修改连接池中的连接
+		for _, client := range pool.Clients {
+			So(client.stockBaseDF.Err, ShouldBeNil)
+			So(client.stockBaseDF.Nrow(), ShouldEqual, 0)
+		}
+
+		// 读取证券列表失败时不通知
+		<-pool.Finished
+		configure.Tdx.Files.StockList = "missing.csv"
+		So(pool.UpdateDays(ctx), ShouldNotBeNil)
+		So(len(pool.Finished), ShouldEqual, 0)
+		So(pool.Clients[0].stockBaseDF.Err, ShouldBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+package ctdx
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/datochan/gcom/cnet"
+	"github.com/datochan/gcom/logger"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+const (
+	defaultMinBackoff = time.Second            // MinBackoff 为0时第一次重连前的等待时间
+	defaultMaxBackoff = time.Minute            // MaxBackoff 为0时两次重连之间的最长等待时间
+	minBackoffFloor   = 10 * time.Millisecond  // 等待时间的下限, 避免服务器不可用时频繁重连
+)
+
+/**
+ * 自动重连的策略, 每次重连失败后等待时间翻倍, 实际等待时间在 [等待时间/2, 等待时间] 之间随机
+ */
+type ReconnectPolicy struct {
+	MaxAttempts int            // 最大重连次数, 0为不限制
+	MinBackoff  time.Duration  // 第一次重连前的等待时间, 0为1秒, 最少10毫秒
+	MaxBackoff  time.Duration  // 两次重连之间的最长等待时间, 0为1分钟, 不小于 MinBackoff
+}
+
+/**
+ * 补全未设置或过小的等待时间
+ */
+func (policy *ReconnectPolicy) backoffRange() (minBackoff, maxBackoff time.Duration) {
+	minBackoff, maxBackoff = policy.MinBackoff, policy.MaxBackoff
+	if 0 >= minBackoff { minBackoff = defaultMinBackoff }
+	if minBackoff < minBackoffFloor { minBackoff = minBackoffFloor }
+	if 0 >= maxBackoff { maxBackoff = defaultMaxBackoff }
+	if maxBackoff < minBackoff { maxBackoff = minBackoff }
+	return minBackoff, maxBackoff
+}
+
+/**
+ * 在 [backoff/2, backoff] 之间随机取值, 避免多个客户端同时重连
+ */
+func jitter(backoff time.Duration) time.Duration {
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+/**
+ * 默认的重连策略: 最多重连10次, 等待时间由1秒开始, 最长1分钟
+ */
+func NewDefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{MaxAttempts: 10, MinBackoff: time.Second, MaxBackoff: time.Minute}
+}
+
+/**
+ * 按重连策略重新连接服务器, 连接成功时会重新注册设备并初始化市场信息
+ */
+func (client *TdxClient) reconnect(reconnecting *connDone) {
+	var err error
+	policy := client.Reconnect
+	backoff, maxBackoff := policy.backoffRange()
+
+	for attempt := 1; 0 >= policy.MaxAttempts || attempt <= policy.MaxAttempts; attempt++ {
+		time.Sleep(jitter(backoff))
+		if 1 == atomic.LoadInt32(&client.closed) { err = ErrClosed; break }
+
+		logger.Info("第 %d 次重新连接服务器...", attempt)
+		if err = client.reconnectOnce(); nil == err { client.metrics.reconnected(); break }
+
+		logger.Error("重新连接服务器失败: %v", err)
+		backoff *= 2
+		if backoff > maxBackoff { backoff = maxBackoff }
+	}
+
+	client.connLock.Lock()
+	if client.reconnecting == reconnecting { client.reconnecting = nil }
+	client.connLock.Unlock()
+
+	if nil != err && ErrClosed != err {
+		err = fmt.Errorf("重新连接服务器失败: %v", err)
+		client.notifyDisconnected(err)
+	}
+	reconnecting.close(err)
+}
+
+/**
+ * 重新连接一次, 由断开的服务器的下一个开始尝试所有候选服务器
+ */
+func (client *TdxClient) reconnectOnce() error {
+	hosts, hostIdx := client.rankedHosts()
+	if 1 < len(hosts) { client.setCurrentHost((hostIdx + 1) % len(hosts)) }
+
+	return client.connect(context.Background())
+}
+
+/**
+ * 请求因连接断开而失败且正在自动重连时, 等待重连结束
+ * 重连成功时返回nil, 调用方应重新发送请求, 否则返回错误
+ */
+func (client *TdxClient) waitReconnect(ctx context.Context, err error) error {
+	if ErrDisconnected != err && ErrNotConnected != err { return err }
+
+	client.connLock.RLock()
+	reconnecting := client.reconnecting
+	client.connLock.RUnlock()
+	if nil == reconnecting { return err }
+
+	select {
+	case <-reconnecting.ch:
+		return reconnecting.reason
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+/**
+ * 连接空闲超过 HeartbeatInterval 时发送心跳请求(查询深市证券数量), 心跳失败时断开连接以触发重连
+ */
+func (client *TdxClient) heartbeat(session *cnet.SyncSession, done *connDone) {
+	ticker := time.NewTicker(client.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done.ch:
+			return
+		case <-ticker.C:
+		}
+
+		lastActive := time.Unix(0, atomic.LoadInt64(&client.lastActive))
+		if time.Since(lastActive) < client.HeartbeatInterval { continue }
+
+		_, err := client.roundTrip(context.Background(), pkg.GenerateMarketStockCount(0))
+		if nil == err { continue }
+
+		select {
+		case <-done.ch:
+			return
+		default:
+			logger.Error("心跳请求失败, 断开连接: %v", err)
+			session.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package ctdx
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"context"
+
+	"github.com/datochan/ctdx/comm"
+)
+
+/**
+ * 候选服务器的探测结果
+ */
+type HostProbe struct {
+	Host     string
+	Latency  time.Duration  // 建立连接并完成初始化所用的时间
+	LastDate uint32         // 服务器上的最后交易日期, 落后于其它服务器说明数据未更新
+	Err      error          // 连接失败的原因
+}
+
+/**
+ * 同时连接所有候选服务器, 按以下顺序返回探测结果:
+ * 最后交易日期最新的服务器(按延迟排序), 数据落后的服务器(按延迟排序), 连接失败的服务器
+ */
+func ProbeHosts(ctx context.Context, configure comm.IConfigure, hosts []string) []HostProbe {
+	var wg sync.WaitGroup
+	probeList := make([]HostProbe, len(hosts))
+
+	for idx, host := range hosts {
+		wg.Add(1)
+		go func(idx int, host string) {
+			defer wg.Done()
+			probeList[idx] = probeHost(ctx, configure, host)
+		}(idx, host)
+	}
+	wg.Wait()
+
+	var maxDate uint32
+	for _, probe := range probeList {
+		if nil == probe.Err && probe.LastDate > maxDate { maxDate = probe.LastDate }
+	}
+
+	rank := func(probe HostProbe) int {
+		if nil != probe.Err { return 2 }
+		if maxDate != probe.LastDate { return 1 }
+		return 0
+	}
+
+	sort.SliceStable(probeList, func(i, j int) bool {
+		if rank(probeList[i]) != rank(probeList[j]) { return rank(probeList[i]) < rank(probeList[j]) }
+		return probeList[i].Latency < probeList[j].Latency
+	})
+
+	return probeList
+}
+
+/**
+ * 连接一次服务器, 记录初始化所用的时间及最后交易日期
+ */
+func probeHost(ctx context.Context, configure comm.IConfigure, host string) HostProbe {
+	probe := HostProbe{Host: host}
+
+	client := NewDefaultTdxClient(configure)
+	client.Hosts = []string{host}
+	defer client.Close()
+
+	start := time.Now()
+	if probe.Err = client.Conn(ctx); nil != probe.Err { return probe }
+
+	probe.Latency = time.Since(start)
+	probe.LastDate = client.GetLastTradeDate()
+
+	return probe
+}
+
+/**
+ * 探测所有候选服务器, 并按探测结果重新排列 Hosts, 之后的连接及重连都由最好的服务器开始尝试
+ */
+func (client *TdxClient) SelectHost(ctx context.Context) ([]HostProbe, error) {
+	probeList := ProbeHosts(ctx, client.Configure, client.candidateHosts())
+	if 0 == len(probeList) { return nil, fmt.Errorf("没有配置服务器地址") }
+	if nil != probeList[0].Err { return probeList, fmt.Errorf("所有服务器均连接失败: %v", probeList[0].Err) }
+
+	hosts := make([]string, len(probeList))
+	for idx, probe := range probeList { hosts[idx] = probe.Host }
+
+	client.connLock.Lock()
+	client.Hosts, client.hostIdx, client.hostsRanked = hosts, 0, true
+	client.connLock.Unlock()
+
+	return probeList, nil
+}
+
+/**
+ * 当前连接(或最后一次连接成功)的服务器
+ */
+func (client *TdxClient) CurrentHost() string {
+	hosts, hostIdx := client.rankedHosts()
+	if 0 == len(hosts) { return "" }
+
+	return hosts[hostIdx]
+}
+
+/**
+ * 候选服务器: Hosts, 为空时依次使用配置中的 data_hosts 及 data_host
+ */
+func (client *TdxClient) candidateHosts() []string {
+	client.connLock.RLock()
+	defer client.connLock.RUnlock()
+
+	if 0 < len(client.Hosts) { return client.Hosts }
+
+	server := client.Configure.GetTdx().Server
+	if 0 < len(server.DataHosts) { return server.DataHosts }
+	if 0 < len(server.DataHost) { return []string{server.DataHost} }
+
+	return nil
+}
+
+func (client *TdxClient) rankedHosts() ([]string, int) {
+	hosts := client.candidateHosts()
+
+	client.connLock.RLock()
+	defer client.connLock.RUnlock()
+	if client.hostIdx >= len(hosts) { return hosts, 0 }
+
+	return hosts, client.hostIdx
+}
+
+func (client *TdxClient) setCurrentHost(hostIdx int) {
+	client.connLock.Lock()
+	client.hostIdx = hostIdx
+	client.connLock.Unlock()
+}
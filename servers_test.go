@@ -0,0 +1,94 @@
+package ctdx
+
+import (
+	"net"
+	"time"
+	"context"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/datochan/ctdx/tdxtest"
+)
+
+func deadHost() string {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	host := listener.Addr().String()
+	listener.Close()
+	return host
+}
+
+func TestServers(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("测试探测并选择服务器", t, func() {
+		staleFixture := tdxtest.NewFixture()
+		staleFixture.LastDate = 20180104
+
+		staleServer, err := tdxtest.NewServer(staleFixture)
+		So(err, ShouldBeNil)
+		defer staleServer.Close()
+
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		dead := deadHost()
+		tdxClient := newTestClient("")
+		tdxClient.Hosts = []string{dead, staleServer.Addr(), server.Addr()}
+		defer tdxClient.Close()
+
+		probeList, err := tdxClient.SelectHost(ctx)
+		So(err, ShouldBeNil)
+		So(probeList, ShouldHaveLength, 3)
+		So(probeList[0].Host, ShouldEqual, server.Addr())
+		So(probeList[0].LastDate, ShouldEqual, 20180105)
+		So(probeList[1].Host, ShouldEqual, staleServer.Addr())
+		So(probeList[2].Host, ShouldEqual, dead)
+		So(probeList[2].Err, ShouldNotBeNil)
+		So(tdxClient.Hosts, ShouldResemble, []string{server.Addr(), staleServer.Addr(), dead})
+
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		So(tdxClient.CurrentHost(), ShouldEqual, server.Addr())
+		So(tdxClient.GetLastTradeDate(), ShouldEqual, 20180105)
+	})
+
+	Convey("测试服务器连接失败时尝试下一个服务器", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		tdxClient := newTestClient("")
+		tdxClient.Hosts = []string{deadHost(), server.Addr()}
+		tdxClient.hostsRanked = true
+		defer tdxClient.Close()
+
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		So(tdxClient.CurrentHost(), ShouldEqual, server.Addr())
+	})
+
+	Convey("测试重连时切换到其它服务器", t, func() {
+		server, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer server.Close()
+
+		backupServer, err := tdxtest.NewServer(tdxtest.NewFixture())
+		So(err, ShouldBeNil)
+		defer backupServer.Close()
+
+		tdxClient := newTestClient("")
+		tdxClient.Hosts = []string{server.Addr(), backupServer.Addr()}
+		tdxClient.hostsRanked = true
+		tdxClient.Reconnect = &ReconnectPolicy{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+		So(tdxClient.Conn(ctx), ShouldBeNil)
+		defer tdxClient.Close()
+		So(tdxClient.CurrentHost(), ShouldEqual, server.Addr())
+
+		server.Close()
+
+		stockList, err := tdxClient.GetStockList(ctx, 1)
+		So(err, ShouldBeNil)
+		So(stockList, ShouldHaveLength, 2)
+		So(tdxClient.CurrentHost(), ShouldEqual, backupServer.Addr())
+		So(len(tdxClient.Disconnected), ShouldEqual, 0)
+	})
+}
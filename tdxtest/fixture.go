@@ -0,0 +1,126 @@
+package tdxtest
+
+import (
+	"fmt"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+// 模拟服务器应答所使用的数据
+type Fixture struct {
+	ServerName string                             // 券商名称
+	LastDate   uint32                             // 市场最后交易日期 yyyymmdd
+	Stocks     [2][]pkg.StockBaseItem             // 股债基列表, 按市场(0深, 1沪)存放
+	Bonus      map[string][]pkg.StockBonusItem    // 市场标识+股票代码 => 权息数据
+	DayBars    map[string][]pkg.StockDayItem      // 市场标识+股票代码 => 日线数据
+	MinsBars   map[string][]pkg.StockMinsItem     // 市场标识+股票代码 => 分钟线数据
+	MinuteTimes map[string][]MinuteTimeItem       // 市场标识+股票代码 => 分时数据(当日及历史相同)
+}
+
+// 分时数据中的一个点, 价格单位为分
+type MinuteTimeItem struct {
+	Price    int
+	AvgPrice int
+	Volume   int
+}
+
+/**
+ * 默认的测试数据: 深沪各两只证券, 每只证券若干天的日线及五分钟线
+ */
+func NewFixture() *Fixture {
+	fixture := &Fixture{ServerName: "tdxtest", LastDate: 20180105,
+		Bonus: make(map[string][]pkg.StockBonusItem),
+		DayBars: make(map[string][]pkg.StockDayItem),
+		MinsBars: make(map[string][]pkg.StockMinsItem),
+		MinuteTimes: make(map[string][]MinuteTimeItem)}
+
+	fixture.AddStock(0, "000001", "PAYH", 13.70)
+	fixture.AddStock(0, "399001", "SZCZ", 11040.45)
+	fixture.AddStock(1, "600000", "PFYH", 12.59)
+	fixture.AddStock(1, "000001", "SZZS", 3307.17)
+
+	fixture.AddBonus(0, "000001", pkg.StockBonusItem{Date: 20170713, Type: 1, Money: 1.58})
+
+	for _, market := range []int{0, 1} {
+		for _, stock := range fixture.Stocks[market] {
+			code := stock.Code
+			price := uint32(stock.Price * 100)
+			for idx, date := range []uint32{20180102, 20180103, 20180104, 20180105} {
+				fixture.AddDayBar(market, code, pkg.StockDayItem{Date: date,
+					Open: price, High: price+uint32(idx+1), Low: price-uint32(idx+1), Close: price+uint32(idx),
+					Amount: float32(price)*1000, Volume: 1000})
+				fixture.AddMinsBar(market, code, pkg.StockMinsItem{Date: PackMinsDate(date), Time: 9*60+35,
+					Open: stock.Price, High: stock.Price, Low: stock.Price, Close: stock.Price,
+					Amount: stock.Price*100, Volume: 100})
+			}
+			for idx, delta := range []int{0, 2, -1} {
+				fixture.AddMinuteTime(market, code, MinuteTimeItem{Price: int(price)+delta,
+					AvgPrice: int(price)+idx/2, Volume: 100*(idx+1)})
+			}
+		}
+	}
+
+	return fixture
+}
+
+/**
+ * 市场标识+股票代码, 与ctdx中文件命名的规则一致
+ */
+func stockKey(market int, code string) string {
+	return fmt.Sprintf("%d%s", market, code)
+}
+
+/**
+ * 分钟线中的日期格式: (年-2004)*2048 + 月*100 + 日
+ */
+func PackMinsDate(date uint32) uint16 {
+	return uint16((date/10000-2004)*2048 + date%10000)
+}
+
+func unpackMinsDate(date uint16) uint32 {
+	return (uint32(date)/2048+2004)*10000 + uint32(date)%2048
+}
+
+/**
+ * 添加证券, name 为UTF-8编码, 发送时转换为GBK
+ */
+func (f *Fixture) AddStock(market int, code, name string, price float32) {
+	var item pkg.StockBaseItem
+	item.Code = code
+	item.Name = name
+	item.Unknown1 = 0x64
+	item.Unknown3 = 0x02
+	item.Price = price
+
+	f.Stocks[market] = append(f.Stocks[market], item)
+}
+
+func (f *Fixture) AddBonus(market int, code string, item pkg.StockBonusItem) {
+	item.Market = byte(market)
+	item.Code = code
+
+	key := stockKey(market, code)
+	f.Bonus[key] = append(f.Bonus[key], item)
+
+	// 证券列表中的权息数量需要与权息数据保持一致
+	for idx := range f.Stocks[market] {
+		if f.Stocks[market][idx].Code == code {
+			f.Stocks[market][idx].Bonus2 = uint16(len(f.Bonus[key]))
+		}
+	}
+}
+
+func (f *Fixture) AddDayBar(market int, code string, item pkg.StockDayItem) {
+	key := stockKey(market, code)
+	f.DayBars[key] = append(f.DayBars[key], item)
+}
+
+func (f *Fixture) AddMinsBar(market int, code string, item pkg.StockMinsItem) {
+	key := stockKey(market, code)
+	f.MinsBars[key] = append(f.MinsBars[key], item)
+}
+
+func (f *Fixture) AddMinuteTime(market int, code string, item MinuteTimeItem) {
+	key := stockKey(market, code)
+	f.MinuteTimes[key] = append(f.MinuteTimes[key], item)
+}
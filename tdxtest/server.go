@@ -0,0 +1,396 @@
+package tdxtest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"compress/zlib"
+	"encoding/binary"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+const (
+	requestHeaderSize = 12          // 请求封包的包头长度
+	responseFlag      = 0x0074CBB1  // 应答封包的封包标识
+	stockBaseLimit    = 0x03E8      // 单次应答的最大证券数量
+)
+
+// 故障注入的类型
+type Fault int
+
+const (
+	FaultNone           Fault = iota
+	FaultDisconnect               // 收到请求后直接断开连接, 不做应答
+	FaultMalformed                // 应答包头损坏的封包(包体长度远大于实际发送的数据)
+	FaultBadCompression           // 应答声明已压缩但无法解压的封包
+	FaultTruncated                // 只发送一半的封包后断开连接
+)
+
+// 收到的请求封包
+type Request struct {
+	Index   uint16
+	CmdId   uint16
+	IsRaw   byte
+	EventId uint16
+	Body    []byte
+}
+
+// 请求的处理过程, 返回应答的包体, 包体为nil时不做应答
+type HandlerFunc func(req Request) ([]byte, error)
+
+// 进程内的通达信模拟服务器
+type Server struct {
+	listener net.Listener
+	fixture  *Fixture
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	handlers map[uint16]HandlerFunc
+	faults   map[uint16][]Fault
+	requests []Request
+	delay    time.Duration
+	compress bool
+	wg       sync.WaitGroup
+}
+
+/**
+ * 在本地随机端口上启动模拟服务器
+ */
+func NewServer(fixture *Fixture) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err { return nil, err }
+
+	if nil == fixture { fixture = NewFixture() }
+
+	server := &Server{listener: listener, fixture: fixture, compress: true,
+		conns: make(map[net.Conn]struct{}),
+		handlers: make(map[uint16]HandlerFunc),
+		faults: make(map[uint16][]Fault)}
+
+	server.handlers[pkg.GenerateDeviceNode(0, 0).EventId] = server.onDevice
+	server.handlers[pkg.GenerateMarketInitInfo().EventId] = server.onMarketInitInfo
+	server.handlers[pkg.GenerateMarketStockCount(0).EventId] = server.onStockCount
+	server.handlers[pkg.GenerateNotice().EventId] = server.onNotice
+	server.handlers[pkg.GenerateMarketStockBase(0, 0).EventId] = server.onStockBase
+	server.handlers[pkg.GenerateStockBonus(nil, 0).EventId] = server.onStockBonus
+	server.handlers[pkg.GenerateStockDayItem(0, "", 0, 0, 0).EventId] = server.onStockBars
+	server.handlers[pkg.GenerateMinuteTimeShare(0, "").EventId] = server.onMinuteTimeShare
+	server.handlers[pkg.GenerateHistoryMinuteTimeShare(0, "", 0).EventId] = server.onMinuteTimeShare
+
+	server.wg.Add(1)
+	go server.acceptLoop()
+
+	return server, nil
+}
+
+/**
+ * 服务器的监听地址, 可直接作为 data_host 使用
+ */
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+/**
+ * 关闭服务器及所有的连接
+ */
+func (s *Server) Close() {
+	s.listener.Close()
+	s.Disconnect()
+	s.wg.Wait()
+}
+
+/**
+ * 断开当前所有的客户端连接, 服务器继续监听
+ */
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+/**
+ * 设置(或覆盖)指定事件的处理过程
+ */
+func (s *Server) Handle(eventId uint16, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[eventId] = handler
+}
+
+/**
+ * 每次应答前的延迟
+ */
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+/**
+ * 是否压缩应答的包体, 默认压缩
+ */
+func (s *Server) SetCompress(compress bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compress = compress
+}
+
+/**
+ * 对指定事件的下一个请求注入故障, 多次调用按顺序生效
+ */
+func (s *Server) InjectFault(eventId uint16, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[eventId] = append(s.faults[eventId], fault)
+}
+
+/**
+ * 已收到的所有请求
+ */
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if nil != err { return }
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	for {
+		req, err := readRequest(conn)
+		if nil != err { return }
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		handler := s.handlers[req.EventId]
+		delay := s.delay
+		compress := s.compress
+		fault := FaultNone
+		if faults := s.faults[req.EventId]; len(faults) > 0 {
+			fault, s.faults[req.EventId] = faults[0], faults[1:]
+		}
+		s.mu.Unlock()
+
+		if FaultDisconnect == fault { return }
+		if nil == handler { continue }
+
+		body, err := handler(req)
+		if nil != err { return }
+		if nil == body { continue }
+
+		if delay > 0 { time.Sleep(delay) }
+
+		if err = writeResponse(conn, req, body, compress, fault); nil != err { return }
+		if FaultTruncated == fault { return }
+	}
+}
+
+/**
+ * 读取一个完整的请求封包
+ */
+func readRequest(conn net.Conn) (Request, error) {
+	var req Request
+	header := make([]byte, requestHeaderSize)
+
+	if _, err := io.ReadFull(conn, header); nil != err { return req, err }
+	if 0x0C != header[0] { return req, errors.New("无效的请求封包标识") }
+
+	req.Index = binary.LittleEndian.Uint16(header[1:3])
+	req.CmdId = binary.LittleEndian.Uint16(header[3:5])
+	req.IsRaw = header[5]
+	req.EventId = binary.LittleEndian.Uint16(header[10:12])
+
+	bodyLength := int(binary.LittleEndian.Uint16(header[6:8])) - 2
+	if bodyLength < 0 { return req, errors.New("无效的请求封包长度") }
+
+	req.Body = make([]byte, bodyLength)
+	_, err := io.ReadFull(conn, req.Body)
+
+	return req, err
+}
+
+/**
+ * 组装并发送应答封包
+ */
+func writeResponse(conn net.Conn, req Request, body []byte, compress bool, fault Fault) error {
+	var newBuffer bytes.Buffer
+	header := pkg.ResponseHeader{PacketFlag: responseFlag, IsCompress: 0x0C, Index: req.Index,
+		CmdId: req.CmdId, EventId: req.EventId, BodyMaxLength: uint16(len(body))}
+
+	payload := body
+	if compress || FaultBadCompression == fault {
+		var zBuffer bytes.Buffer
+		writer := zlib.NewWriter(&zBuffer)
+		writer.Write(body)
+		writer.Close()
+
+		header.IsCompress |= 0x10
+		payload = zBuffer.Bytes()
+		if FaultBadCompression == fault { payload = bytes.Repeat([]byte{0xFF}, len(payload)) }
+	}
+	header.BodyLength = uint16(len(payload))
+
+	if FaultMalformed == fault { header.BodyLength = 0xFFFF }
+
+	binary.Write(&newBuffer, binary.LittleEndian, header)
+	newBuffer.Write(payload)
+
+	packet := newBuffer.Bytes()
+	if FaultTruncated == fault { packet = packet[:len(packet)/2] }
+
+	_, err := conn.Write(packet)
+	return err
+}
+
+func (s *Server) onDevice(req Request) ([]byte, error) {
+	return []byte{0x00}, nil
+}
+
+func (s *Server) onMarketInitInfo(req Request) ([]byte, error) {
+	var info pkg.MarketInitInfo
+	info.DateSZ = s.fixture.LastDate
+	info.DateSH = s.fixture.LastDate
+	info.ServerName = s.fixture.ServerName
+	info.DomainUrl = "127.0.0.1"
+
+	return pkg.Marshal(info)
+}
+
+func (s *Server) onStockCount(req Request) ([]byte, error) {
+	var newBuffer bytes.Buffer
+
+	market := 0
+	if 0x6C == req.CmdId { market = 1 }
+
+	err := binary.Write(&newBuffer, binary.LittleEndian, uint16(len(s.fixture.Stocks[market])))
+	return newBuffer.Bytes(), err
+}
+
+func (s *Server) onNotice(req Request) ([]byte, error) {
+	return append(make([]byte, 0xB2), bytes.Repeat([]byte("tdxtest notice "), 8)...), nil
+}
+
+func (s *Server) onStockBase(req Request) ([]byte, error) {
+	if len(req.Body) < 4 { return nil, errors.New("无效的证券列表请求") }
+
+	market := int(binary.LittleEndian.Uint16(req.Body[0:2])) & 0x01
+	offset := int(binary.LittleEndian.Uint16(req.Body[2:4]))
+
+	stockList := s.fixture.Stocks[market]
+	if offset > len(stockList) { offset = len(stockList) }
+	stockList = stockList[offset:]
+	if len(stockList) > stockBaseLimit { stockList = stockList[:stockBaseLimit] }
+
+	return pkg.Marshal(pkg.StockBaseList{Items: stockList})
+}
+
+func (s *Server) onStockBonus(req Request) ([]byte, error) {
+	var stockBonusList pkg.StockBonusList
+	if len(req.Body) < 2 { return nil, errors.New("无效的权息请求") }
+
+	stockCount := int(binary.LittleEndian.Uint16(req.Body[0:2]))
+	if len(req.Body) < 2+stockCount*7 { return nil, errors.New("无效的权息请求") }
+
+	for idx := 0; idx < stockCount; idx++ {
+		stock := req.Body[2+idx*7 : 2+idx*7+7]
+		bonusGroup := pkg.StockBonusGroup{Market: stock[0], Code: string(stock[1:]),
+			Items: s.fixture.Bonus[stockKey(int(stock[0]), string(stock[1:]))]}
+
+		stockBonusList.Groups = append(stockBonusList.Groups, bonusGroup)
+	}
+
+	return pkg.Marshal(stockBonusList)
+}
+
+func (s *Server) onStockBars(req Request) ([]byte, error) {
+	if len(req.Body) < 16 { return nil, errors.New("无效的K线请求") }
+
+	market := int(binary.LittleEndian.Uint16(req.Body[0:2]))
+	key := stockKey(market, string(bytes.TrimRight(req.Body[2:8], "\x00")))
+	start := binary.LittleEndian.Uint32(req.Body[8:12])
+	end := binary.LittleEndian.Uint32(req.Body[12:16])
+
+	if 0x008D == req.CmdId {
+		var stockMinsList pkg.StockMinsList
+		for _, item := range s.fixture.MinsBars[key] {
+			date := unpackMinsDate(item.Date)
+			if date >= start && date <= end { stockMinsList.Items = append(stockMinsList.Items, item) }
+		}
+		return pkg.Marshal(stockMinsList)
+	}
+
+	var stockDayList pkg.StockDayList
+	for _, item := range s.fixture.DayBars[key] {
+		if item.Date >= start && item.Date <= end { stockDayList.Items = append(stockDayList.Items, item) }
+	}
+	return pkg.Marshal(stockDayList)
+}
+
+// 分时数据中的一个点, 价格及均价均为与前一个点的差值
+type minuteTimePoint struct {
+	Price    int `tdx:"price"`
+	AvgPrice int `tdx:"price"`
+	Volume   int `tdx:"price"`
+}
+
+func (s *Server) onMinuteTimeShare(req Request) ([]byte, error) {
+	var newBuffer bytes.Buffer
+	var market int
+	var code []byte
+
+	isHistory := pkg.GenerateHistoryMinuteTimeShare(0, "", 0).EventId == req.EventId
+	if isHistory {
+		if len(req.Body) < 11 { return nil, errors.New("无效的历史分时请求") }
+		market, code = int(req.Body[4]), req.Body[5:11]
+	} else {
+		if len(req.Body) < 8 { return nil, errors.New("无效的分时请求") }
+		market, code = int(binary.LittleEndian.Uint16(req.Body[0:2])), req.Body[2:8]
+	}
+
+	minuteList := s.fixture.MinuteTimes[stockKey(market, string(bytes.TrimRight(code, "\x00")))]
+	binary.Write(&newBuffer, binary.LittleEndian, uint16(len(minuteList)))
+	if isHistory {
+		newBuffer.Write(make([]byte, 4))  // 昨收价
+	} else {
+		newBuffer.Write(make([]byte, 2))  // 未知
+	}
+
+	var lastPrice, lastAvgPrice int
+	for _, item := range minuteList {
+		rawData, err := pkg.Marshal(minuteTimePoint{item.Price-lastPrice, item.AvgPrice-lastAvgPrice, item.Volume})
+		if nil != err { return nil, err }
+		newBuffer.Write(rawData)
+		lastPrice, lastAvgPrice = item.Price, item.AvgPrice
+	}
+
+	return newBuffer.Bytes(), nil
+}
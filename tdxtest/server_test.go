@@ -0,0 +1,113 @@
+package tdxtest
+
+import (
+	"io"
+	"net"
+	"time"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"compress/zlib"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+
+	pkg "github.com/datochan/ctdx/packet"
+)
+
+// 发送请求并读取应答, 返回应答包头及解压后的包体
+func roundTrip(conn net.Conn, reqNode pkg.RequestNode) (pkg.ResponseHeader, []byte, error) {
+	var header pkg.ResponseHeader
+
+	if _, err := conn.Write(pkg.NewDefaultProtocol().BuildPacket(reqNode)); nil != err {
+		return header, nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := binary.Read(conn, binary.LittleEndian, &header); nil != err { return header, nil, err }
+
+	body := make([]byte, header.BodyLength)
+	if _, err := io.ReadFull(conn, body); nil != err { return header, nil, err }
+	if 0 == header.IsCompress & 0x10 { return header, body, nil }
+
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if nil != err { return header, nil, err }
+	body, err = ioutil.ReadAll(reader)
+
+	return header, body, err
+}
+
+func TestServer(t *testing.T) {
+	server, err := NewServer(nil)
+	if nil != err { t.Fatal(err) }
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr())
+	if nil != err { t.Fatal(err) }
+	defer conn.Close()
+
+	Convey("测试市场股票数量的应答", t, func() {
+		header, body, err := roundTrip(conn, pkg.GenerateMarketStockCount(1))
+		So(err, ShouldBeNil)
+		So(header.EventId, ShouldEqual, 0x044E)
+		So(header.BodyMaxLength, ShouldEqual, len(body))
+		So(binary.LittleEndian.Uint16(body), ShouldEqual, 2)
+	})
+
+	Convey("测试证券列表的应答", t, func() {
+		_, body, err := roundTrip(conn, pkg.GenerateMarketStockBase(0, 1))
+		So(err, ShouldBeNil)
+		So(binary.LittleEndian.Uint16(body), ShouldEqual, 1)
+		So(string(body[2:8]), ShouldEqual, "399001")
+	})
+
+	Convey("测试日线数据的应答", t, func() {
+		server.SetCompress(false)
+		defer server.SetCompress(true)
+
+		header, body, err := roundTrip(conn, pkg.GenerateStockDayItem(1, "600000", 20180103, 20180104, 5))
+		So(err, ShouldBeNil)
+		So(header.IsCompress & 0x10, ShouldEqual, 0)
+		So(header.Index, ShouldEqual, 5)
+		So(binary.LittleEndian.Uint32(body[2:6]), ShouldEqual, 2*binary.Size(pkg.StockDayItem{}))
+		So(binary.LittleEndian.Uint32(body[6:10]), ShouldEqual, 20180103)
+	})
+
+	Convey("测试五分钟线数据的应答", t, func() {
+		_, body, err := roundTrip(conn, pkg.GenerateStockMinsItem(0, "000001", 20180105, 20180105, 1))
+		So(err, ShouldBeNil)
+		So(binary.LittleEndian.Uint32(body[2:6]), ShouldEqual, binary.Size(pkg.StockMinsItem{}))
+		So(binary.LittleEndian.Uint16(body[6:8]), ShouldEqual, PackMinsDate(20180105))
+	})
+
+	Convey("测试权息数据的应答", t, func() {
+		var code [6]byte
+		copy(code[:], "000001")
+		_, body, err := roundTrip(conn, pkg.GenerateStockBonus([]pkg.StockBonus{{0, code}}, 0))
+		So(err, ShouldBeNil)
+		So(binary.LittleEndian.Uint16(body[0:2]), ShouldEqual, 1)
+		So(binary.LittleEndian.Uint16(body[9:11]), ShouldEqual, 1)
+	})
+
+	Convey("测试注入无法解压的封包", t, func() {
+		server.InjectFault(0x044E, FaultBadCompression)
+		_, _, err := roundTrip(conn, pkg.GenerateMarketStockCount(0))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("测试应答延迟", t, func() {
+		server.SetDelay(50 * time.Millisecond)
+		defer server.SetDelay(0)
+
+		start := time.Now()
+		_, _, err := roundTrip(conn, pkg.GenerateMarketStockCount(0))
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50 * time.Millisecond)
+	})
+
+	Convey("测试注入断开连接", t, func() {
+		server.InjectFault(0x044E, FaultDisconnect)
+		_, _, err := roundTrip(conn, pkg.GenerateMarketStockCount(0))
+		So(err, ShouldNotBeNil)
+		So(len(server.Requests()), ShouldBeGreaterThan, 0)
+	})
+}